@@ -0,0 +1,189 @@
+package slowpoke
+
+import (
+	"bytes"
+	"sort"
+)
+
+// Batch is a set of writes over one or more files
+// Writes are applied all-or-nothing on Commit, even after crash
+// Batch is not safe for concurrent use
+//
+//	err := slowpoke.NewBatch().
+//		Set(posts, id, post).
+//		Set(tags, tag, nil).
+//		Commit()
+type Batch struct {
+	ops  map[string]map[string]txOp
+	done bool
+}
+
+// NewBatch return empty batch
+func NewBatch() *Batch {
+	return &Batch{ops: make(map[string]map[string]txOp)}
+}
+
+// Set store val and key in file on commit
+func (b *Batch) Set(file string, key, val []byte) *Batch {
+	b.add(newSetOp(file, key, val))
+	return b
+}
+
+// Delete key from file on commit
+func (b *Batch) Delete(file string, key []byte) *Batch {
+	b.add(newDeleteOp(file, key))
+	return b
+}
+
+// Commit apply all writes of batch
+// Readers and writers of all files of batch wait while batch applied
+func (b *Batch) Commit() error {
+	if b.done {
+		return ErrTxDone
+	}
+	b.done = true
+	ops := b.ops
+	b.ops = nil
+	return commit(ops)
+}
+
+func (b *Batch) add(op txOp) {
+	if b.ops[op.file] == nil {
+		b.ops[op.file] = make(map[string]txOp)
+	}
+	b.ops[op.file][string(op.key)] = op
+}
+
+// newSetOp return set op with copy of key and val
+func newSetOp(file string, key, val []byte) txOp {
+	op := txOp{file: file, key: append([]byte{}, key...)}
+	if val != nil {
+		op.val = append([]byte{}, val...)
+	}
+	return op
+}
+
+// newDeleteOp return delete op with copy of key
+func newDeleteOp(file string, key []byte) txOp {
+	return txOp{file: file, key: append([]byte{}, key...), del: true}
+}
+
+// commit write journal near every file and apply ops (by file, by key)
+func commit(ops map[string]map[string]txOp) error {
+	names := make([]string, 0, len(ops))
+	for name := range ops {
+		names = append(names, name)
+	}
+	return commitFunc(names, func() (map[string]map[string]txOp, error) {
+		return ops, nil
+	})
+}
+
+// commitFunc lock files with their indexes, build ops and commit them
+// build is called while files locked, so it may read current values,
+// ops of build must be only for given files
+func commitFunc(names []string, build func() (map[string]map[string]txOp, error)) error {
+	if len(names) == 0 {
+		return nil
+	}
+	fs, err := lockIndexed(names)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		for _, f := range fs {
+			f.Unlock()
+		}
+	}()
+	ops, err := build()
+	if err != nil {
+		return err
+	}
+	if err = addIndexOps(fs, ops); err != nil {
+		return err
+	}
+
+	locked := make([]*dbFile, 0, len(fs))
+	for _, f := range fs {
+		if len(ops[f.name]) > 0 {
+			if err = f.writable(); err != nil {
+				return err
+			}
+			locked = append(locked, f)
+		}
+	}
+	if len(locked) == 0 {
+		return nil
+	}
+	// in-memory files have no journal
+	var all []txOp
+	var durable []*dbFile
+	j := &journal{}
+	for _, f := range locked {
+		start := len(all)
+		for _, op := range ops[f.name] {
+			all = append(all, op)
+		}
+		fileOps := all[start:]
+		sort.Slice(fileOps, func(i, k int) bool {
+			return bytes.Compare(fileOps[i].key, fileOps[k].key) < 0
+		})
+		if !f.opts.InMemory {
+			if len(durable) == 0 {
+				j = newJournal(f.name)
+			}
+			durable = append(durable, f)
+			sealed, err := f.sealOps(fileOps)
+			if err != nil {
+				return err
+			}
+			j.ops = append(j.ops, sealed...)
+		}
+	}
+	// primary journal written last and removed last
+	for i := len(durable) - 1; i >= 0; i-- {
+		if err = writeJournal(durable[i].name, j, durable[i].opts.FileMode); err != nil {
+			for _, f := range durable[i+1:] {
+				removeJournal(f.name)
+			}
+			return err
+		}
+	}
+	for _, f := range locked {
+		if err = f.apply(all); err != nil {
+			// batch committed, so journals will be replayed on next open
+			for _, f := range durable {
+				f.drop()
+			}
+			return err
+		}
+	}
+	for i := len(durable) - 1; i >= 0; i-- {
+		if err = removeJournal(durable[i].name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// lockFiles open and lock files in order of names, names must be sorted
+func lockFiles(names []string) ([]*dbFile, error) {
+	fs := make([]*dbFile, 0, len(names))
+	for len(fs) < len(names) {
+		f, err := openFile(names[len(fs)])
+		if err != nil {
+			for _, f := range fs {
+				f.Unlock()
+			}
+			return nil, err
+		}
+		f.Lock()
+		if f.closed {
+			// closed while we wait, open again
+			f.Unlock()
+			continue
+		}
+		fs = append(fs, f)
+	}
+	return fs, nil
+}
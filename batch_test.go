@@ -0,0 +1,95 @@
+package slowpoke
+
+import (
+	"bytes"
+	"os"
+	"testing"
+)
+
+func TestBatch(t *testing.T) {
+	posts, tags := "test/TestBatchPosts.db", "test/TestBatchTags.db"
+	DeleteFile(posts)
+	DeleteFile(tags)
+	defer CloseAll()
+	ch(Set(tags, []byte("old"), nil), t)
+
+	err := NewBatch().
+		Set(posts, []byte("1"), []byte("post")).
+		Set(tags, []byte("new:1"), nil).
+		Delete(tags, []byte("old")).
+		Commit()
+	ch(err, t)
+	if v, _ := Get(posts, []byte("1")); !bytes.Equal(v, []byte("post")) {
+		t.Error("post not stored")
+	}
+	if has, _ := Has(tags, []byte("new:1")); !has {
+		t.Error("tag not stored")
+	}
+	if has, _ := Has(tags, []byte("old")); has {
+		t.Error("tag not deleted")
+	}
+	for _, f := range []string{posts, tags} {
+		if _, err = os.Stat(journalName(f)); !os.IsNotExist(err) {
+			t.Error("journal not removed", f, err)
+		}
+	}
+	b := NewBatch()
+	ch(b.Commit(), t)
+	if b.Commit() != ErrTxDone {
+		t.Error("commit twice")
+	}
+}
+
+func TestBatchCrash(t *testing.T) {
+	posts, tags := "test/TestBatchCrashPosts.db", "test/TestBatchCrashTags.db"
+	crash := func(journals ...string) {
+		CloseAll()
+		DeleteFile(posts)
+		DeleteFile(tags)
+		j := newJournal(posts)
+		j.ops = []txOp{
+			{file: posts, key: []byte("1"), val: []byte("post")},
+			{file: tags, key: []byte("tag:1")},
+		}
+		for _, f := range journals {
+			ch(writeJournal(f, j, 0666), t)
+		}
+	}
+	committed := func(want bool) {
+		hasPost, _ := Has(posts, []byte("1"))
+		hasTag, _ := Has(tags, []byte("tag:1"))
+		if hasPost != want || hasTag != want {
+			t.Error("want committed", want, "post", hasPost, "tag", hasTag)
+		}
+		for _, f := range []string{posts, tags} {
+			if _, err := os.Stat(journalName(f)); !os.IsNotExist(err) {
+				t.Error("journal not removed", f, err)
+			}
+		}
+	}
+	defer CloseAll()
+
+	// crash before primary journal written
+	crash(tags)
+	committed(false)
+
+	// crash after primary journal written, primary opened first
+	crash(tags, posts)
+	db, _ := Open(posts, nil)
+	db.Close()
+	committed(true)
+
+	// crash after primary journal written, secondary opened first
+	crash(tags, posts)
+	db, _ = Open(tags, nil)
+	db.Close()
+	if _, err := os.Stat(journalName(tags)); !os.IsNotExist(err) {
+		t.Error("secondary journal not replayed", err)
+	}
+	committed(true)
+
+	// crash after secondary journal applied and removed
+	crash(posts)
+	ch(Set(tags, []byte("tag:1"), nil), t)
+	committed(true)
+}
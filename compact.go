@@ -0,0 +1,419 @@
+package slowpoke
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/recoilme/pudge"
+)
+
+// FileStats describe disk usage of file
+type FileStats struct {
+	Keys      int   // count of keys
+	Size      int64 // size of value and index files
+	LiveBytes int64 // bytes used by values and index records of keys
+	DeadBytes int64 // bytes used by deleted and overwritten values
+}
+
+// DeadRatio return part of file size used by dead bytes
+func (s *FileStats) DeadRatio() float64 {
+	if s.Size == 0 {
+		return 0
+	}
+	return float64(s.DeadBytes) / float64(s.Size)
+}
+
+// Stats return disk usage of file
+// Use it to decide when to call Compact
+func Stats(file string) (*FileStats, error) {
+	f, err := openFile(file)
+	if err != nil {
+		return nil, err
+	}
+	f.RLock()
+	defer f.RUnlock()
+	return f.stats()
+}
+
+// stats read index and return disk usage, file must be locked
+func (f *dbFile) stats() (*FileStats, error) {
+	s := &FileStats{}
+	if f.opts.InMemory {
+		cnt, err := f.db.Count()
+		s.Keys = cnt
+		return s, err
+	}
+	for _, name := range []string{f.name, f.name + ".idx"} {
+		fi, err := os.Stat(name)
+		if err != nil {
+			return nil, err
+		}
+		s.Size += fi.Size()
+	}
+	live, err := liveIndex(f.name)
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range live {
+		s.Keys++
+		s.LiveBytes += int64(r.size) + r.recordSize()
+	}
+	s.LiveBytes += f.format.dataStart()
+	s.DeadBytes = s.Size - s.LiveBytes
+	return s, nil
+}
+
+// Compact rewrite values of file into new file and replace file with it
+// Use it to reclaim space after Delete and overwrite
+// Readers and writers keep working while values copied,
+// and wait only while changed keys copied and files replaced.
+// In-memory file is not compacted.
+// DB returned by Open keeps working, file is reopened by compaction.
+// Values of encrypted file and its index files are re-encrypted
+// by current key
+func Compact(file string) error {
+	f, err := openFile(file)
+	if err != nil {
+		return err
+	}
+	if err = f.compact(); err != nil || !f.encrypted() {
+		return err
+	}
+	for _, idx := range indexesOf(file) {
+		xf, err := openFile(idx.file)
+		if err == nil {
+			err = xf.compact()
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// compactName return name of new file while compaction
+func compactName(file string) string {
+	return file + ".compact"
+}
+
+// compactedName return name of marker of finished compaction
+// If marker exists - new file is complete and must replace file
+func compactedName(file string) string {
+	return file + ".compacted"
+}
+
+func (f *dbFile) compact() error {
+	if err := f.writable(); err != nil || f.opts.InMemory {
+		return err
+	}
+	f.compactMu.Lock()
+	defer f.compactMu.Unlock()
+	tmp := compactName(f.name)
+	removeCompaction(f.name)
+	// new file has current format
+	ft, err := f.newFormat()
+	if err == nil {
+		ft, err = createHeader(tmp, f.opts.FileMode, ft)
+	}
+	var mac []byte
+	if err == nil {
+		mac, err = f.keyMAC(ft)
+	}
+	if err != nil {
+		removeCompaction(f.name)
+		return err
+	}
+	db, err := pudge.Open(tmp, f.config())
+	if err != nil {
+		removeCompaction(f.name)
+		return err
+	}
+	failed := func(err error) error {
+		db.Close()
+		removeCompaction(f.name)
+		return err
+	}
+	err = f.copyLive(db, ft, mac, func() error {
+		if err := db.Close(); err != nil {
+			return err
+		}
+		if err := markCompacted(f.name, f.opts.FileMode); err != nil {
+			return err
+		}
+		if err := f.db.Close(); err != nil {
+			return err
+		}
+		err := finishCompaction(f.name)
+		if err == nil {
+			f.format, f.mac = ft, mac
+			f.db, err = pudge.Open(f.name, f.config())
+		}
+		if err == nil {
+			err = f.rewriteTTL()
+		}
+		if err != nil {
+			// file can't be used, it will be opened again on next use
+			f.forget()
+			f.closeTTL()
+			f.releaseLock()
+		}
+		return err
+	})
+	if err != nil {
+		return failed(err)
+	}
+	return nil
+}
+
+// copyLive copy live values of file into db of format ft with HMAC key
+// of keys mac, compactMu must be locked
+// Readers and writers keep working while values copied, keys changed
+// meanwhile are copied again under write lock of file and locked
+// is called under the same lock, so db is consistent copy of file
+func (f *dbFile) copyLive(db *pudge.Db, ft format, mac []byte, locked func() error) error {
+	// copy all values, keys changed meanwhile will be copied again
+	// tracking starts under write lock, so no write is in progress
+	f.Lock()
+	if f.closed {
+		f.Unlock()
+		return os.ErrClosed
+	}
+	f.dirtyMu.Lock()
+	f.dirty = make(map[string]struct{})
+	f.dirtyMu.Unlock()
+	keys, err := f.keyDB().Keys(nil, 0, 0, true)
+	f.Unlock()
+	defer func() {
+		f.dirtyMu.Lock()
+		f.dirty = nil
+		f.dirtyMu.Unlock()
+	}()
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		f.RLock()
+		val, err := f.read(key)
+		f.RUnlock()
+		if err == pudge.ErrKeyNotFound {
+			continue
+		}
+		dk := storedKey(mac, key)
+		if err == nil {
+			val, err = f.encode(ft, dk, key, val)
+		}
+		if err == nil {
+			err = db.Set(dk, val)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	f.Lock()
+	defer f.Unlock()
+	if f.closed {
+		return os.ErrClosed
+	}
+	for key := range f.dirty {
+		val, err := f.read([]byte(key))
+		dk := storedKey(mac, []byte(key))
+		switch err {
+		case nil:
+			if val, err = f.encode(ft, dk, []byte(key), val); err == nil {
+				err = db.Set(dk, val)
+			}
+		case pudge.ErrKeyNotFound:
+			if err = db.Delete(dk); err == pudge.ErrKeyNotFound {
+				err = nil
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return locked()
+}
+
+// restoredMark is content of marker of compaction written by Restore:
+// expiration time of file is replaced with compacted one (or removed
+// if there is none) before file is replaced
+var restoredMark = []byte("restored")
+
+// markCompacted create marker of finished compaction
+func markCompacted(file string, mode os.FileMode) error {
+	return writeMarker(file, nil, mode)
+}
+
+// markRestored create marker of finished compaction of Restore
+func markRestored(file string, mode os.FileMode) error {
+	return writeMarker(file, restoredMark, mode)
+}
+
+// writeMarker create marker of finished compaction with content b
+func writeMarker(file string, b []byte, mode os.FileMode) error {
+	if err := ioutil.WriteFile(compactedName(file), b, mode); err != nil {
+		return err
+	}
+	syncDir(file)
+	return nil
+}
+
+// finishCompaction replace file with compacted file if compaction finished
+// or remove new file if not
+func finishCompaction(file string) error {
+	mark, err := ioutil.ReadFile(compactedName(file))
+	if err != nil {
+		if !os.IsNotExist(err) {
+			return err
+		}
+		removeCompaction(file)
+		return nil
+	}
+	if bytes.Equal(mark, restoredMark) {
+		// marker of expiration time has mode of marker of file
+		fi, err := os.Stat(compactedName(file))
+		if err == nil {
+			err = replaceTTL(file, fi.Mode().Perm())
+		}
+		if err != nil {
+			return err
+		}
+	}
+	tmp := compactName(file)
+	for _, ext := range []string{"", ".idx"} {
+		err := os.Rename(tmp+ext, file+ext)
+		// file may be renamed before crash
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	syncDir(file)
+	return os.Remove(compactedName(file))
+}
+
+// replaceTTL mark compacted expiration time of restored file as finished,
+// or remove expiration time if snapshot has none
+// Compacted expiration time is moved by finishCompaction of it
+func replaceTTL(file string, mode os.FileMode) error {
+	ttl := ttlName(file)
+	if _, err := os.Stat(compactName(ttl)); err == nil {
+		return markCompacted(ttl, mode)
+	}
+	for _, ext := range []string{"", ".idx"} {
+		if err := os.Remove(ttl + ext); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// removeCompaction remove files of not finished compaction
+func removeCompaction(file string) {
+	tmp := compactName(file)
+	os.Remove(tmp)
+	os.Remove(tmp + ".idx")
+	os.Remove(compactedName(file))
+}
+
+// CompactionPolicy describe when file compacted automatically
+// File checked every Interval and compacted if it's bigger then MinSize
+// and part of dead bytes more then DeadRatio
+type CompactionPolicy struct {
+	DeadRatio     float64       // default 0.5
+	MinSize       int64         // in bytes
+	MaxConcurrent int           // max count of automatic compactions of all files at same time, default 1
+	QuietFrom     int           // if QuietFrom != QuietTo compact only from QuietFrom
+	QuietTo       int           // to QuietTo hour of local time, may be over midnight (22-6)
+	Interval      time.Duration // default 1 minute
+}
+
+// now return current time, replaced in tests
+var now = time.Now
+
+// compactions is count of running automatic compactions
+var compactions struct {
+	sync.Mutex
+	running int
+}
+
+// quiet return true if t in quiet hours
+func (p *CompactionPolicy) quiet(t time.Time) bool {
+	if p.QuietFrom == p.QuietTo {
+		return true
+	}
+	h := t.Hour()
+	if p.QuietFrom < p.QuietTo {
+		return h >= p.QuietFrom && h < p.QuietTo
+	}
+	return h >= p.QuietFrom || h < p.QuietTo
+}
+
+// need return true if file with stats must be compacted
+func (p *CompactionPolicy) need(s *FileStats) bool {
+	ratio := p.DeadRatio
+	if ratio <= 0 {
+		ratio = 0.5
+	}
+	return s.Size >= p.MinSize && s.DeadBytes > 0 && s.DeadRatio() >= ratio
+}
+
+// setPolicy stop automatic compaction and start it with new policy, file must be locked
+// nil policy - only stop
+func (f *dbFile) setPolicy(p *CompactionPolicy) {
+	if f.stopPolicy != nil {
+		close(f.stopPolicy)
+		f.stopPolicy = nil
+	}
+	if p == nil {
+		return
+	}
+	f.stopPolicy = make(chan struct{})
+	go f.autoCompact(*p, f.stopPolicy)
+}
+
+// autoCompact check file every interval and compact it if needed
+func (f *dbFile) autoCompact(p CompactionPolicy, stop chan struct{}) {
+	interval := p.Interval
+	if interval <= 0 {
+		interval = time.Minute
+	}
+	max := p.MaxConcurrent
+	if max <= 0 {
+		max = 1
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+		if !p.quiet(now()) {
+			continue
+		}
+		f.RLock()
+		s, err := f.stats()
+		f.RUnlock()
+		if err != nil || !p.need(s) {
+			continue
+		}
+		compactions.Lock()
+		if compactions.running >= max {
+			compactions.Unlock()
+			continue
+		}
+		compactions.running++
+		compactions.Unlock()
+
+		f.compact()
+
+		compactions.Lock()
+		compactions.running--
+		compactions.Unlock()
+	}
+}
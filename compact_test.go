@@ -0,0 +1,167 @@
+package slowpoke
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestCompact(t *testing.T) {
+	f := "test/TestCompact.db"
+	DeleteFile(f)
+	defer Close(f)
+	for i := 0; i < 100; i++ {
+		k := []byte(fmt.Sprintf("%03d", i))
+		ch(Set(f, k, bytes.Repeat(k, 10)), t)
+		ch(Set(f, k, bytes.Repeat(k, 20)), t)
+		if i%2 == 0 {
+			Delete(f, k)
+		}
+	}
+	st, err := Stats(f)
+	ch(err, t)
+	if st.Keys != 50 || st.DeadBytes <= 0 || st.DeadRatio() <= 0.5 {
+		t.Errorf("wrong stats %+v", st)
+	}
+	size := st.Size
+
+	ch(Compact(f), t)
+	st, err = Stats(f)
+	ch(err, t)
+	if st.Keys != 50 || st.DeadBytes != 0 || st.Size >= size {
+		t.Errorf("not compacted %+v", st)
+	}
+	Close(f)
+	for i := 0; i < 100; i++ {
+		k := []byte(fmt.Sprintf("%03d", i))
+		v, _ := Get(f, k)
+		if i%2 == 0 && v != nil || i%2 != 0 && !bytes.Equal(v, bytes.Repeat(k, 20)) {
+			t.Error("wrong value", string(k), string(v))
+		}
+	}
+	for _, name := range []string{compactName(f), compactName(f) + ".idx", compactedName(f)} {
+		if _, err = os.Stat(name); !os.IsNotExist(err) {
+			t.Error("not removed", name, err)
+		}
+	}
+}
+
+func TestCompactAsync(t *testing.T) {
+	f := "test/TestCompactAsync.db"
+	DeleteFile(f)
+	defer Close(f)
+	for i := 0; i < 1000; i++ {
+		k := []byte(fmt.Sprintf("%04d", i))
+		ch(Set(f, k, k), t)
+	}
+	var wg sync.WaitGroup
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := w; i < 1000; i += 4 {
+				k := []byte(fmt.Sprintf("%04d", i))
+				if i%3 == 0 {
+					_, err := Delete(f, k)
+					ch(err, t)
+				} else {
+					ch(Set(f, k, append(k, 'x')), t)
+				}
+				if v, err := Get(f, k); i%3 != 0 && (err != nil || !bytes.Equal(v, append(k, 'x'))) {
+					t.Error("wrong value while compaction", string(k), string(v), err)
+				}
+			}
+		}(w)
+	}
+	ch(Compact(f), t)
+	wg.Wait()
+	Close(f)
+	for i := 0; i < 1000; i++ {
+		k := []byte(fmt.Sprintf("%04d", i))
+		v, err := Get(f, k)
+		if i%3 == 0 && err == nil || i%3 != 0 && !bytes.Equal(v, append(k, 'x')) {
+			t.Error("lost write", string(k), string(v), err)
+		}
+	}
+}
+
+func TestCompactCrash(t *testing.T) {
+	f := "test/TestCompactCrash.db"
+	DeleteFile(f)
+	defer Close(f)
+	ch(Set(f, []byte("1"), []byte("old")), t)
+	Close(f)
+
+	// crash while new file written
+	ch(ioutil.WriteFile(compactName(f), []byte("garbage"), 0666), t)
+	if v, _ := Get(f, []byte("1")); !bytes.Equal(v, []byte("old")) {
+		t.Error("not finished compaction applied", string(v))
+	}
+	if _, err := os.Stat(compactName(f)); !os.IsNotExist(err) {
+		t.Error("new file not removed", err)
+	}
+	Close(f)
+
+	// crash after new value file renamed, but not index
+	tmp := compactName(f)
+	ch(Set(tmp, []byte("1"), []byte("new")), t)
+	Close(tmp)
+	ch(os.Rename(tmp, f), t)
+	ch(ioutil.WriteFile(compactedName(f), nil, 0666), t)
+	if v, _ := Get(f, []byte("1")); !bytes.Equal(v, []byte("new")) {
+		t.Error("finished compaction not applied", string(v))
+	}
+	if _, err := os.Stat(compactedName(f)); !os.IsNotExist(err) {
+		t.Error("marker not removed", err)
+	}
+}
+
+func TestCompactionPolicy(t *testing.T) {
+	f := "test/TestCompactionPolicy.db"
+	DeleteFile(f)
+	defer Close(f)
+	db, err := Open(f, &Options{Compaction: &CompactionPolicy{DeadRatio: 0.3, Interval: 10 * time.Millisecond}})
+	ch(err, t)
+	defer db.Close()
+	for i := 0; i < 10; i++ {
+		ch(Set(f, []byte("key"), bytes.Repeat([]byte{byte(i)}, 100+i)), t)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		st, err := Stats(f)
+		ch(err, t)
+		if st.DeadBytes == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("not compacted %+v", st)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if v, _ := Get(f, []byte("key")); !bytes.Equal(v, bytes.Repeat([]byte{9}, 109)) {
+		t.Error("wrong value", v)
+	}
+
+	p := &CompactionPolicy{MinSize: 1000}
+	if p.need(&FileStats{Size: 999, DeadBytes: 999}) || !p.need(&FileStats{Size: 1000, DeadBytes: 500}) {
+		t.Error("wrong need")
+	}
+	at := func(h int) time.Time {
+		return time.Date(2020, 1, 1, h, 30, 0, 0, time.Local)
+	}
+	p = &CompactionPolicy{QuietFrom: 22, QuietTo: 6}
+	if !p.quiet(at(23)) || !p.quiet(at(5)) || p.quiet(at(6)) || p.quiet(at(12)) {
+		t.Error("wrong quiet hours over midnight")
+	}
+	p = &CompactionPolicy{QuietFrom: 1, QuietTo: 5}
+	if !p.quiet(at(1)) || p.quiet(at(5)) || p.quiet(at(0)) {
+		t.Error("wrong quiet hours")
+	}
+	if !(&CompactionPolicy{}).quiet(at(12)) {
+		t.Error("no quiet hours must allow compaction")
+	}
+}
@@ -0,0 +1,172 @@
+package slowpoke
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// Compressor compress values of file opened with Options.Compressor
+// Every compressed value is stored with ID of compressor, so file may
+// have values of different compressors. Values compressed by Flate
+// are always readable, values of other compressors need Compressor
+// with the same ID in options
+// Options are compared on Open: Compressor of pointer, map, slice or func
+// type is compared by identity, other by value, it may be not comparable
+type Compressor interface {
+	// ID of compressor 1..127, 1 is Flate
+	ID() byte
+	Compress(src []byte) ([]byte, error)
+	Decompress(src []byte) ([]byte, error)
+}
+
+// ErrUnknownCodec returned by reads of value compressed
+// by compressor, which is not in options of file
+var ErrUnknownCodec = errors.New("slowpoke: value compressed by unknown compressor")
+
+// Flate is Compressor of compress/flate
+type Flate struct {
+	// Level of compression, 0 - flate.DefaultCompression
+	Level int
+}
+
+// flateID is ID of Flate
+const flateID = 1
+
+// ID return 1
+func (c Flate) ID() byte {
+	return flateID
+}
+
+// flateWriters is pool of writers by level + 2 (flate.HuffmanOnly)
+var flateWriters [flate.BestCompression + 3]sync.Pool
+
+// Compress return deflated src
+func (c Flate) Compress(src []byte) ([]byte, error) {
+	level := c.Level
+	if level == 0 {
+		level = flate.DefaultCompression
+	}
+	if level < flate.HuffmanOnly || level > flate.BestCompression {
+		return nil, fmt.Errorf("slowpoke: invalid flate level %d", c.Level)
+	}
+	var buf bytes.Buffer
+	pool := &flateWriters[level-flate.HuffmanOnly]
+	w, _ := pool.Get().(*flate.Writer)
+	if w == nil {
+		var err error
+		if w, err = flate.NewWriter(&buf, level); err != nil {
+			return nil, err
+		}
+	} else {
+		w.Reset(&buf)
+	}
+	_, err := w.Write(src)
+	if err == nil {
+		err = w.Close()
+	}
+	pool.Put(w)
+	return buf.Bytes(), err
+}
+
+// Decompress return inflated src
+func (c Flate) Decompress(src []byte) ([]byte, error) {
+	r := flate.NewReader(bytes.NewReader(src))
+	defer r.Close()
+	return io.ReadAll(r)
+}
+
+// compressMin is default Options.CompressMin
+const compressMin = 64
+
+// flagSealed is set in compressor ID of encrypted value
+const flagSealed = 0x80
+
+// encode return stored bytes of value of key in file of format ft,
+// dk is key stored in file
+// Record of format with flagCodec is compressor ID (with flagSealed if value
+// encrypted) and value, value of format with flagKeys is prefixed by key
+// Value is compressed if file has compressor, value isn't short
+// and compressed value is smaller
+func (f *dbFile) encode(ft format, dk, key, val []byte) ([]byte, error) {
+	if (f.encrypted() && ft.flags&flagCodec == 0) || (f.opts.EncryptKeys && !f.opts.InMemory && ft.flags&flagKeys == 0) {
+		return nil, fmt.Errorf("%w: %s", ErrMigrate, f.name)
+	}
+	if ft.flags&flagCodec == 0 {
+		return ft.encodeValue(dk, val), nil
+	}
+	if ft.flags&flagKeys != 0 {
+		var size [binary.MaxVarintLen64]byte
+		b := append(size[:binary.PutUvarint(size[:], uint64(len(key)))], key...)
+		val = append(b, val...)
+	}
+	id := byte(0)
+	if c := f.opts.Compressor; c != nil && len(val) >= f.opts.CompressMin {
+		if c.ID() == 0 || c.ID() >= flagSealed {
+			return nil, fmt.Errorf("slowpoke: invalid compressor ID %d", c.ID())
+		}
+		b, err := c.Compress(val)
+		if err != nil {
+			return nil, err
+		}
+		if len(b) < len(val) {
+			id, val = c.ID(), b
+		}
+	}
+	if f.encrypted() {
+		b, err := f.seal(val, dk)
+		if err != nil {
+			return nil, err
+		}
+		id, val = id|flagSealed, b
+	}
+	return ft.encodeValue(dk, append([]byte{id}, val...)), nil
+}
+
+// decodeRecord return key (if stored with value) and value of stored bytes,
+// dk is key stored in file
+// Return *CorruptedError if checksum of value not match,
+// *KeyError if value can't be decrypted
+func (f *dbFile) decodeRecord(dk, b []byte) (key, val []byte, err error) {
+	val, ok := f.format.decodeValue(dk, b)
+	if !ok || (f.format.flags&flagCodec != 0 && len(val) == 0) {
+		return nil, nil, f.corrupted(dk)
+	}
+	if f.format.flags&flagCodec == 0 {
+		return nil, val, nil
+	}
+	id, val := val[0], val[1:]
+	if id&flagSealed != 0 {
+		if val, err = f.unseal(val, dk); err != nil {
+			return nil, nil, err
+		}
+		id &^= flagSealed
+	}
+	var c Compressor
+	switch {
+	case id == 0:
+	case f.opts.Compressor != nil && f.opts.Compressor.ID() == id:
+		c = f.opts.Compressor
+	case id == flateID:
+		c = Flate{}
+	default:
+		return nil, nil, fmt.Errorf("%w: value in %s has compressor %d", ErrUnknownCodec, f.name, id)
+	}
+	if c != nil {
+		if val, err = c.Decompress(val); err != nil {
+			return nil, nil, fmt.Errorf("slowpoke: decompress value in %s: %w", f.name, err)
+		}
+	}
+	if f.format.flags&flagKeys == 0 {
+		return nil, val, nil
+	}
+	size, n := binary.Uvarint(val)
+	if n <= 0 || uint64(len(val)-n) < size {
+		return nil, nil, f.corrupted(dk)
+	}
+	return val[n : n+int(size)], val[n+int(size):], nil
+}
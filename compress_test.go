@@ -0,0 +1,159 @@
+package slowpoke
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+)
+
+// reverse is test compressor of values starting with 'x':
+// 'x' is dropped and rest is reversed
+type reverse struct{}
+
+func (reverse) ID() byte {
+	return 7
+}
+
+func (reverse) Compress(src []byte) ([]byte, error) {
+	b := make([]byte, 0, len(src)-1)
+	for i := len(src) - 1; i > 0; i-- {
+		b = append(b, src[i])
+	}
+	return b, nil
+}
+
+func (reverse) Decompress(src []byte) ([]byte, error) {
+	b := []byte{'x'}
+	for i := len(src) - 1; i >= 0; i-- {
+		b = append(b, src[i])
+	}
+	return b, nil
+}
+
+func TestCompress(t *testing.T) {
+	f := "test/TestCompress.db"
+	DeleteFile(f)
+	defer DeleteFile(f)
+	doc := bytes.Repeat([]byte(`{"name":"slowpoke","tags":["kv","go"]}`), 50)
+	db, err := Open(f, &Options{Compressor: Flate{}})
+	ch(err, t)
+	for i := 0; i < 100; i++ {
+		ch(db.Set([]byte(fmt.Sprintf("%03d", i)), doc), t)
+	}
+	ch(db.Set([]byte("small"), []byte("raw")), t)
+	st, err := db.Stats()
+	ch(err, t)
+	if st.Size > int64(len(doc))*100/5 {
+		t.Error("values not compressed", st.Size)
+	}
+	if v, err := db.Get([]byte("050")); err != nil || !bytes.Equal(v, doc) {
+		t.Error("get", len(v), err)
+	}
+	ch(db.Close(), t)
+
+	// flate values are readable without compressor
+	if v, err := Get(f, []byte("099")); err != nil || !bytes.Equal(v, doc) {
+		t.Error("get without compressor", len(v), err)
+	}
+	if v, err := Get(f, []byte("small")); err != nil || string(v) != "raw" {
+		t.Error("get of small value", string(v), err)
+	}
+	ch(Close(f), t)
+
+	// values of other compressor need it in options
+	db, err = Open(f, &Options{Compressor: reverse{}, CompressMin: 1})
+	ch(err, t)
+	ch(db.Set([]byte("r"), []byte("xabc")), t)
+	if v, err := db.Get([]byte("r")); err != nil || string(v) != "xabc" {
+		t.Error("get of custom compressor", string(v), err)
+	}
+	if v, err := db.Get([]byte("000")); err != nil || !bytes.Equal(v, doc) {
+		t.Error("get of flate value", len(v), err)
+	}
+	ch(db.Close(), t)
+	if _, err = Get(f, []byte("r")); !errors.Is(err, ErrUnknownCodec) {
+		t.Error("unknown compressor", err)
+	}
+	ch(Close(f), t)
+}
+
+func TestCompressCompact(t *testing.T) {
+	f := "test/TestCompressCompact.db"
+	DeleteFile(f)
+	defer DeleteFile(f)
+	doc := bytes.Repeat([]byte("compressed value "), 20)
+	for i := 0; i < 50; i++ {
+		ch(Set(f, []byte(fmt.Sprintf("%03d", i)), doc), t)
+	}
+	st, err := Stats(f)
+	ch(err, t)
+	size := st.Size
+	ch(Close(f), t)
+
+	// stored values are compressed by compaction
+	db, err := Open(f, &Options{Compressor: Flate{Level: 9}})
+	ch(err, t)
+	ch(db.Compact(), t)
+	if st, _ = db.Stats(); st.Size*4 > size {
+		t.Error("not compressed by compaction", st.Size, size)
+	}
+	ch(db.Close(), t)
+	if v, err := Get(f, []byte("049")); err != nil || !bytes.Equal(v, doc) {
+		t.Error("get after compaction", string(v), err)
+	}
+	ch(Close(f), t)
+
+	// file of format without compression is readable and migrated
+	g := "test/TestCompressCompact2.db"
+	DeleteFile(g)
+	defer DeleteFile(g)
+	os.MkdirAll("test", 0777)
+	_, err = createHeader(g, 0666, currentFormat)
+	ch(err, t)
+	fd, err := os.OpenFile(g, os.O_RDWR, 0)
+	ch(err, t)
+	flags := make([]byte, 2)
+	binary.BigEndian.PutUint16(flags, flagChecksum)
+	_, err = fd.WriteAt(flags, int64(len(headerMagic)+2))
+	ch(err, t)
+	fd.Close()
+	db, err = Open(g, &Options{Compressor: Flate{}})
+	ch(err, t)
+	ch(db.Set([]byte("a"), doc), t)
+	if v, err := db.Get([]byte("a")); err != nil || !bytes.Equal(v, doc) {
+		t.Error("get of old format", string(v), err)
+	}
+	ch(Migrate(g), t)
+	if v, err := db.Get([]byte("a")); err != nil || !bytes.Equal(v, doc) {
+		t.Error("get after migrate", string(v), err)
+	}
+	ch(db.Close(), t)
+	if ft, _ := readHeader(g); ft != currentFormat {
+		t.Error("format after migrate", ft)
+	}
+}
+
+// dict is test compressor which is not comparable
+type dict struct {
+	reverse
+	words []string
+}
+
+func TestCompressNotComparable(t *testing.T) {
+	f := "test/TestCompressNotComparable.db"
+	DeleteFile(f)
+	defer DeleteFile(f)
+	opts := &Options{Compressor: dict{words: []string{"a"}}}
+	db, err := Open(f, opts)
+	ch(err, t)
+	defer db.Close()
+	db2, err := Open(f, opts)
+	ch(err, t)
+	ch(db2.Close(), t)
+	if _, err = Open(f, &Options{Compressor: dict{words: []string{"b"}}}); err == nil {
+		t.Error("opened with other compressor")
+	}
+}
@@ -0,0 +1,141 @@
+package slowpoke
+
+import (
+	"context"
+
+	"github.com/recoilme/pudge"
+)
+
+// ctxCheckEvery is count of keys between checks of context in long operations
+const ctxCheckEvery = 256
+
+// GetCtx is Get with context
+func GetCtx(ctx context.Context, file string, key []byte) ([]byte, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return Get(file, key)
+}
+
+// SetCtx is Set with context
+func SetCtx(ctx context.Context, file string, key, val []byte) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return Set(file, key, val)
+}
+
+// CountCtx is Count with context
+func CountCtx(ctx context.Context, file string) (uint64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	return Count(file)
+}
+
+// SetsCtx is Sets with context, context is checked before every pair
+// Pairs stored before cancel are not removed
+func SetsCtx(ctx context.Context, file string, pairs [][]byte) error {
+	f, err := openFile(file)
+	if err != nil {
+		return err
+	}
+	for i := 1; i < len(pairs); i += 2 {
+		if err = ctx.Err(); err != nil {
+			return err
+		}
+		if pairs[i] == nil || pairs[i-1] == nil {
+			break
+		}
+		if err = f.put(pairs[i-1], pairs[i], 0); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetsCtx is Gets with context, return error if context is done
+// or value is corrupted
+func GetsCtx(ctx context.Context, file string, keys [][]byte) (result [][]byte, err error) {
+	f, err := openFile(file)
+	if err != nil {
+		return nil, err
+	}
+	for i, key := range keys {
+		if i%ctxCheckEvery == 0 {
+			if err = ctx.Err(); err != nil {
+				return nil, err
+			}
+		}
+		f.RLock()
+		v, err := f.get(key)
+		f.RUnlock()
+		switch err {
+		case nil:
+			result = append(result, key, v)
+		case pudge.ErrKeyNotFound:
+		default:
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+// KeysCtx is Keys with context, return error if context is done
+// Keys are walked one by one, so cancel of huge scan is fast
+func KeysCtx(ctx context.Context, file string, from []byte, limit, offset uint32, asc bool) ([][]byte, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	f, err := openFile(file)
+	if err != nil {
+		return nil, err
+	}
+	opts := &IteratorOptions{}
+	if len(from) > 1 && from[len(from)-1] == '*' {
+		opts.Prefix = from[:len(from)-1]
+	} else if from != nil {
+		f.RLock()
+		has, err := f.keyDB().Has(from)
+		f.RUnlock()
+		switch {
+		case err != nil:
+			return nil, err
+		case !has && !asc:
+			return [][]byte{}, nil
+		case has && asc:
+			// keys after from
+			opts.Start = append(append([]byte(nil), from...), 0)
+		case has:
+			opts.End = from
+		}
+	}
+	it := NewIterator(file, opts)
+	defer it.Close()
+	next := it.Next
+	if !asc {
+		next = it.Prev
+	}
+	keys := make([][]byte, 0)
+	i := 0
+	for ; next(); i++ {
+		if i%ctxCheckEvery == 0 {
+			if err = ctx.Err(); err != nil {
+				return nil, err
+			}
+		}
+		if offset > 0 {
+			offset--
+			continue
+		}
+		keys = append(keys, it.Key())
+		if uint32(len(keys)) == limit {
+			break
+		}
+	}
+	if err = it.Err(); err == nil && i == 0 && opts.Prefix != nil {
+		// as Keys, no keys with prefix
+		err = pudge.ErrKeyNotFound
+	}
+	return keys, err
+}
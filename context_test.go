@@ -0,0 +1,81 @@
+package slowpoke
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/recoilme/pudge"
+)
+
+func TestCtx(t *testing.T) {
+	f := "test/TestCtx.db"
+	DeleteFile(f)
+	defer Close(f)
+	ctx := context.Background()
+	var pairs [][]byte
+	for i := 1; i <= 20; i++ {
+		k := []byte(fmt.Sprintf("%02d", i))
+		pairs = append(pairs, k, k)
+	}
+	ch(SetsCtx(ctx, f, pairs), t)
+	ch(SetCtx(ctx, f, []byte("k*1"), nil), t)
+	if v, err := GetCtx(ctx, f, []byte("07")); err != nil || string(v) != "07" {
+		t.Error("get", v, err)
+	}
+	if cnt, err := CountCtx(ctx, f); err != nil || cnt != 21 {
+		t.Error("count", cnt, err)
+	}
+	res, err := GetsCtx(ctx, f, [][]byte{[]byte("01"), []byte("nokey")})
+	ch(err, t)
+	if len(res) != 2 || string(res[1]) != "01" {
+		t.Error("gets", res)
+	}
+
+	// KeysCtx return the same keys as Keys
+	for _, from := range []string{"", "10", "33", "1*", "2*", "k*"} {
+		for _, asc := range []bool{true, false} {
+			for _, lo := range [][2]uint32{{0, 0}, {2, 2}, {3, 0}, {0, 30}} {
+				var fromKey []byte
+				if from != "" {
+					fromKey = []byte(from)
+				}
+				want, _ := Keys(f, fromKey, lo[0], lo[1], asc)
+				got, err := KeysCtx(ctx, f, fromKey, lo[0], lo[1], asc)
+				ch(err, t)
+				if !bytes.Equal(bytes.Join(want, nil), bytes.Join(got, nil)) || len(want) != len(got) {
+					t.Errorf("from %q asc %v limit/offset %v: got %q want %q", from, asc, lo, got, want)
+				}
+			}
+		}
+	}
+
+	if keys, err := KeysCtx(ctx, f, []byte("x*"), 0, 0, false); err != pudge.ErrKeyNotFound || len(keys) != 0 {
+		t.Error("not existing prefix", keys, err)
+	}
+	if _, err := Keys(f, []byte("zz*"), 0, 0, true); err != pudge.ErrKeyNotFound {
+		t.Error("keys of not existing prefix", err)
+	}
+	if keys, err := KeysCtx(ctx, f, []byte("zz*"), 0, 0, true); err != pudge.ErrKeyNotFound || len(keys) != 0 {
+		t.Error("not existing prefix asc", keys, err)
+	}
+
+	cctx, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err = KeysCtx(cctx, f, nil, 0, 0, true); err != context.Canceled {
+		t.Error("keys not canceled", err)
+	}
+	if _, err = GetsCtx(cctx, f, [][]byte{[]byte("01")}); err != context.Canceled {
+		t.Error("gets not canceled", err)
+	}
+	if err = SetsCtx(cctx, f, [][]byte{[]byte("21"), []byte("21")}); err != context.Canceled {
+		t.Error("sets not canceled", err)
+	}
+	if has, _ := Has(f, []byte("21")); has {
+		t.Error("stored after cancel")
+	}
+	if _, err = GetCtx(cctx, f, []byte("01")); err != context.Canceled {
+		t.Error("get not canceled", err)
+	}
+}
@@ -0,0 +1,442 @@
+package slowpoke
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrClosed returned by methods of closed DB
+	ErrClosed = errors.New("slowpoke: db is closed")
+	// ErrInUse returned by DeleteFile of file opened by DB
+	ErrInUse = errors.New("slowpoke: file is opened by DB")
+)
+
+// DB is opened file
+// File stay opened until all DB of file closed, Close and CloseAll
+// of package functions don't close it, so libraries using the same file
+// don't close it for each other
+//
+//	db, err := slowpoke.Open("test/posts.db", nil)
+//	defer db.Close()
+//	err = db.Set([]byte("foo"), []byte("bar"))
+type DB struct {
+	mu     sync.RWMutex
+	file   string
+	closed bool
+}
+
+// Open open/create file (with dirs) and return DB
+// Create .idx file for key storage
+// If opts not nil - compaction policy of already opened file will be replaced,
+// file opened by package functions is reopened with other options,
+// but file opened by other DB must be opened with the same options
+func Open(file string, opts *Options) (*DB, error) {
+	if opts != nil && opts.EncryptKeys && opts.Keys == nil {
+		return nil, errors.New("slowpoke: EncryptKeys without Keys")
+	}
+	files.Lock()
+	reopen := false
+	var stale []string
+	if opts != nil {
+		o, cur := opts.withDefaults(), files.opts[file].withDefaults()
+		// timeout is used only while file opened
+		cur.Timeout = o.Timeout
+		held := files.refs[file] > 0 || cur.InMemory
+		if !o.equal(cur) {
+			if held {
+				files.Unlock()
+				return nil, fmt.Errorf("slowpoke: %s is opened with other options", file)
+			}
+			_, reopen = files.m[file]
+		}
+		if !held {
+			files.opts[file] = o
+			// index files are opened with options of file
+			io := indexOptions(o)
+			for _, idx := range indexesOf(file) {
+				if _, ok := files.m[idx.file]; ok && !files.opts[idx.file].equal(io) {
+					stale = append(stale, idx.file)
+				}
+				files.opts[idx.file] = io
+			}
+		}
+	}
+	files.refs[file]++
+	files.Unlock()
+	if reopen {
+		stale = append(stale, file)
+	}
+	for _, name := range stale {
+		if _, err := closeFile(name); err != nil {
+			release(file)
+			return nil, err
+		}
+	}
+	f, err := openFile(file)
+	if err != nil {
+		release(file)
+		return nil, err
+	}
+	if opts != nil && opts.Compaction != nil {
+		f.Lock()
+		f.setPolicy(opts.Compaction)
+		f.Unlock()
+	}
+	return &DB{file: file}, nil
+}
+
+// release decrement count of DB of file and return true if it was last DB
+// Options of file and its indexes are removed with last DB
+func release(file string) bool {
+	files.Lock()
+	defer files.Unlock()
+	files.refs[file]--
+	if files.refs[file] > 0 {
+		return false
+	}
+	delete(files.refs, file)
+	delete(files.opts, file)
+	for _, idx := range indexesOf(file) {
+		delete(files.opts, idx.file)
+	}
+	return true
+}
+
+// Close DB, file is closed with last DB
+// Data of in-memory file is lost
+func (d *DB) Close() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.closed {
+		return ErrClosed
+	}
+	d.closed = true
+	if !release(d.file) {
+		return nil
+	}
+	_, err := closeFile(d.file)
+	for _, idx := range indexesOf(d.file) {
+		if _, e := closeFile(idx.file); err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
+// acquire keep DB opened until done called
+func (d *DB) acquire() error {
+	d.mu.RLock()
+	if d.closed {
+		d.mu.RUnlock()
+		return ErrClosed
+	}
+	return nil
+}
+
+func (d *DB) done() {
+	d.mu.RUnlock()
+}
+
+// File return name of file
+func (d *DB) File() string {
+	return d.file
+}
+
+// Set store val and key
+func (d *DB) Set(key, val []byte) error {
+	if err := d.acquire(); err != nil {
+		return err
+	}
+	defer d.done()
+	return Set(d.file, key, val)
+}
+
+// SetWithTTL store val and key, key expire after ttl
+func (d *DB) SetWithTTL(key, val []byte, ttl time.Duration) error {
+	if err := d.acquire(); err != nil {
+		return err
+	}
+	defer d.done()
+	return SetWithTTL(d.file, key, val, ttl)
+}
+
+// TTL return time to live of key
+func (d *DB) TTL(key []byte) (time.Duration, error) {
+	if err := d.acquire(); err != nil {
+		return 0, err
+	}
+	defer d.done()
+	return TTL(d.file, key)
+}
+
+// Sets store vals and keys, see Sets
+func (d *DB) Sets(pairs [][]byte) error {
+	if err := d.acquire(); err != nil {
+		return err
+	}
+	defer d.done()
+	return Sets(d.file, pairs)
+}
+
+// SetMany store pairs, see SetMany
+func (d *DB) SetMany(pairs []KV) error {
+	if err := d.acquire(); err != nil {
+		return err
+	}
+	defer d.done()
+	return SetMany(d.file, pairs)
+}
+
+// Get return value by key
+func (d *DB) Get(key []byte) ([]byte, error) {
+	if err := d.acquire(); err != nil {
+		return nil, err
+	}
+	defer d.done()
+	return Get(d.file, key)
+}
+
+// Gets return key/value pairs, see Gets
+// Corrupted value is skipped, use GetsCtx or GetMany to get error
+func (d *DB) Gets(keys [][]byte) [][]byte {
+	if err := d.acquire(); err != nil {
+		return nil
+	}
+	defer d.done()
+	return Gets(d.file, keys)
+}
+
+// GetMany return values of keys in order of keys, see GetMany
+func (d *DB) GetMany(keys [][]byte) ([]KV, error) {
+	if err := d.acquire(); err != nil {
+		return nil, err
+	}
+	defer d.done()
+	return GetMany(d.file, keys)
+}
+
+// Has return true if key exists
+func (d *DB) Has(key []byte) (bool, error) {
+	if err := d.acquire(); err != nil {
+		return false, err
+	}
+	defer d.done()
+	return Has(d.file, key)
+}
+
+// Delete key
+func (d *DB) Delete(key []byte) (bool, error) {
+	if err := d.acquire(); err != nil {
+		return false, err
+	}
+	defer d.done()
+	return Delete(d.file, key)
+}
+
+// Count return count of keys
+func (d *DB) Count() (uint64, error) {
+	if err := d.acquire(); err != nil {
+		return 0, err
+	}
+	defer d.done()
+	return Count(d.file)
+}
+
+// Keys return keys, see Keys
+func (d *DB) Keys(from []byte, limit, offset uint32, asc bool) ([][]byte, error) {
+	if err := d.acquire(); err != nil {
+		return nil, err
+	}
+	defer d.done()
+	return Keys(d.file, from, limit, offset, asc)
+}
+
+// KeysByPrefix return keys with prefix, see KeysByPrefix
+func (d *DB) KeysByPrefix(prefix []byte, limit, offset uint32, asc bool) ([][]byte, error) {
+	if err := d.acquire(); err != nil {
+		return nil, err
+	}
+	defer d.done()
+	return KeysByPrefix(d.file, prefix, limit, offset, asc)
+}
+
+// Range return keys between start and end, see Range
+func (d *DB) Range(start, end []byte, opts RangeOptions) ([][]byte, error) {
+	if err := d.acquire(); err != nil {
+		return nil, err
+	}
+	defer d.done()
+	return Range(d.file, start, end, opts)
+}
+
+// NewIterator return iterator over keys, see NewIterator
+func (d *DB) NewIterator(opts *IteratorOptions) *Iterator {
+	return NewIterator(d.file, opts)
+}
+
+// Counter return incremented counter, see Counter
+func (d *DB) Counter(key []byte) (uint64, error) {
+	if err := d.acquire(); err != nil {
+		return 0, err
+	}
+	defer d.done()
+	return Counter(d.file, key)
+}
+
+// CounterAdd add delta to counter, see CounterAdd
+func (d *DB) CounterAdd(key []byte, delta int64) (int64, error) {
+	if err := d.acquire(); err != nil {
+		return 0, err
+	}
+	defer d.done()
+	return CounterAdd(d.file, key, delta)
+}
+
+// Update run read-modify-write of key atomically, see Update
+func (d *DB) Update(key []byte, fn func(old []byte, exists bool) ([]byte, error)) error {
+	if err := d.acquire(); err != nil {
+		return err
+	}
+	defer d.done()
+	return Update(d.file, key, fn)
+}
+
+// CompareAndSwap store new value if current value equal old, see CompareAndSwap
+func (d *DB) CompareAndSwap(key, old, new []byte) (bool, error) {
+	if err := d.acquire(); err != nil {
+		return false, err
+	}
+	defer d.done()
+	return CompareAndSwap(d.file, key, old, new)
+}
+
+// Begin start transaction
+func (d *DB) Begin() (*Tx, error) {
+	if err := d.acquire(); err != nil {
+		return nil, err
+	}
+	defer d.done()
+	return Begin(d.file)
+}
+
+// Stats return stats of file
+func (d *DB) Stats() (*FileStats, error) {
+	if err := d.acquire(); err != nil {
+		return nil, err
+	}
+	defer d.done()
+	return Stats(d.file)
+}
+
+// Compact rewrite live values into new file, see Compact
+func (d *DB) Compact() error {
+	if err := d.acquire(); err != nil {
+		return err
+	}
+	defer d.done()
+	return Compact(d.file)
+}
+
+// Migrate rewrite file in current format, see Migrate
+func (d *DB) Migrate() error {
+	if err := d.acquire(); err != nil {
+		return err
+	}
+	defer d.done()
+	return Migrate(d.file)
+}
+
+// Snapshot return read-only view of file at this moment, see View
+// View must be released
+func (d *DB) Snapshot() (*View, error) {
+	if err := d.acquire(); err != nil {
+		return nil, err
+	}
+	defer d.done()
+	return NewView(d.file)
+}
+
+// CreateIndex create secondary index, see CreateIndex
+func (d *DB) CreateIndex(name string, fn IndexFunc) error {
+	if err := d.acquire(); err != nil {
+		return err
+	}
+	defer d.done()
+	return CreateIndex(d.file, name, fn)
+}
+
+// DropIndex remove secondary index
+func (d *DB) DropIndex(name string) error {
+	if err := d.acquire(); err != nil {
+		return err
+	}
+	defer d.done()
+	return DropIndex(d.file, name)
+}
+
+// QueryIndex return records by index, see QueryIndex
+func (d *DB) QueryIndex(name string, q IndexQuery) ([]KV, error) {
+	if err := d.acquire(); err != nil {
+		return nil, err
+	}
+	defer d.done()
+	return QueryIndex(d.file, name, q)
+}
+
+// GetCtx is Get with context
+func (d *DB) GetCtx(ctx context.Context, key []byte) ([]byte, error) {
+	if err := d.acquire(); err != nil {
+		return nil, err
+	}
+	defer d.done()
+	return GetCtx(ctx, d.file, key)
+}
+
+// SetCtx is Set with context
+func (d *DB) SetCtx(ctx context.Context, key, val []byte) error {
+	if err := d.acquire(); err != nil {
+		return err
+	}
+	defer d.done()
+	return SetCtx(ctx, d.file, key, val)
+}
+
+// SetsCtx is Sets with context
+func (d *DB) SetsCtx(ctx context.Context, pairs [][]byte) error {
+	if err := d.acquire(); err != nil {
+		return err
+	}
+	defer d.done()
+	return SetsCtx(ctx, d.file, pairs)
+}
+
+// GetsCtx is Gets with context
+func (d *DB) GetsCtx(ctx context.Context, keys [][]byte) ([][]byte, error) {
+	if err := d.acquire(); err != nil {
+		return nil, err
+	}
+	defer d.done()
+	return GetsCtx(ctx, d.file, keys)
+}
+
+// KeysCtx is Keys with context
+func (d *DB) KeysCtx(ctx context.Context, from []byte, limit, offset uint32, asc bool) ([][]byte, error) {
+	if err := d.acquire(); err != nil {
+		return nil, err
+	}
+	defer d.done()
+	return KeysCtx(ctx, d.file, from, limit, offset, asc)
+}
+
+// CountCtx is Count with context
+func (d *DB) CountCtx(ctx context.Context) (uint64, error) {
+	if err := d.acquire(); err != nil {
+		return 0, err
+	}
+	defer d.done()
+	return CountCtx(ctx, d.file)
+}
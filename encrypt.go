@@ -0,0 +1,305 @@
+package slowpoke
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/recoilme/pudge"
+)
+
+// Values of file opened with Options.Keys are encrypted by AES-GCM,
+// encrypted value is stored with ID of its key and random nonce:
+//
+//	4 bytes key ID
+//	12 bytes nonce
+//	sealed value (key stored in file is additional data)
+//
+// With Options.EncryptKeys file has flagKeys: key is stored in file
+// as HMAC-SHA256 of key (by key of ID from header) and key itself is
+// stored encrypted with value. Keys are decrypted on open.
+
+// KeyProvider provide AES keys (16, 24 or 32 bytes) of encrypted file
+// New values are encrypted by current key, stored values are decrypted
+// by key of their ID. Compact re-encrypt stored values by current key,
+// so old key can be removed after Compact (key rotation)
+// Options are compared on Open: KeyProvider of pointer, map, slice or func
+// type is compared by identity, other by value, it may be not comparable
+type KeyProvider interface {
+	// CurrentKey return ID and key of new values
+	CurrentKey() (uint32, []byte, error)
+	// Key return key by ID, key of ID must not change
+	Key(id uint32) ([]byte, error)
+}
+
+// ErrWrongKey is matched by errors.Is for *KeyError
+var ErrWrongKey = errors.New("slowpoke: wrong encryption key")
+
+// KeyError returned if value can't be decrypted:
+// key of ID is unknown or it's not key value was encrypted with
+type KeyError struct {
+	File  string
+	KeyID uint32
+}
+
+func (e *KeyError) Error() string {
+	return fmt.Sprintf("slowpoke: %s can't be decrypted with key %d", e.File, e.KeyID)
+}
+
+// Unwrap return ErrWrongKey
+func (e *KeyError) Unwrap() error {
+	return ErrWrongKey
+}
+
+// ErrMigrate returned by writes if format of file doesn't support
+// options of file, Migrate file to write it
+var ErrMigrate = errors.New("slowpoke: format of file doesn't support options, Migrate it")
+
+// Keyring is KeyProvider of keys in memory
+type Keyring struct {
+	mu      sync.RWMutex
+	current uint32
+	keys    map[uint32][]byte
+}
+
+// NewKeyring return Keyring with current key of id
+func NewKeyring(id uint32, key []byte) *Keyring {
+	k := &Keyring{keys: make(map[uint32][]byte)}
+	k.Add(id, key)
+	return k
+}
+
+// Add add key of id and make it current
+func (k *Keyring) Add(id uint32, key []byte) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.keys[id] = append([]byte(nil), key...)
+	k.current = id
+}
+
+// Remove remove key of id, current key is not removed
+func (k *Keyring) Remove(id uint32) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if id != k.current {
+		delete(k.keys, id)
+	}
+}
+
+// CurrentKey return ID and key of new values
+func (k *Keyring) CurrentKey() (uint32, []byte, error) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return k.current, k.keys[k.current], nil
+}
+
+// Key return key by ID
+func (k *Keyring) Key(id uint32) ([]byte, error) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	key, ok := k.keys[id]
+	if !ok {
+		return nil, fmt.Errorf("slowpoke: key %d not found", id)
+	}
+	return key, nil
+}
+
+// sealedHeader is size of key ID and nonce of encrypted data
+const sealedHeader = 4 + 12
+
+// encrypted return true if values of file are encrypted
+func (f *dbFile) encrypted() bool {
+	return f.opts.Keys != nil && !f.opts.InMemory
+}
+
+// aead return cipher of key, ciphers are cached by key ID
+func (f *dbFile) aead(id uint32, key []byte) (cipher.AEAD, error) {
+	if c, ok := f.aeads.Load(id); ok {
+		return c.(cipher.AEAD), nil
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	c, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	f.aeads.Store(id, c)
+	return c, nil
+}
+
+// seal encrypt b by current key, ad is additional data
+func (f *dbFile) seal(b, ad []byte) ([]byte, error) {
+	id, key, err := f.opts.Keys.CurrentKey()
+	if err != nil {
+		return nil, err
+	}
+	c, err := f.aead(id, key)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]byte, sealedHeader, sealedHeader+len(b)+c.Overhead())
+	binary.BigEndian.PutUint32(out, id)
+	if _, err = rand.Read(out[4:sealedHeader]); err != nil {
+		return nil, err
+	}
+	return c.Seal(out, out[4:sealedHeader], b, ad), nil
+}
+
+// unseal decrypt b encrypted by seal
+// Return *KeyError if key of b is unknown or wrong
+func (f *dbFile) unseal(b, ad []byte) ([]byte, error) {
+	if len(b) < sealedHeader {
+		return nil, errors.New("slowpoke: sealed data is too short")
+	}
+	id := binary.BigEndian.Uint32(b)
+	if f.opts.Keys == nil {
+		return nil, &KeyError{File: f.name, KeyID: id}
+	}
+	c, ok := f.aeads.Load(id)
+	if !ok {
+		key, err := f.opts.Keys.Key(id)
+		if err != nil {
+			return nil, &KeyError{File: f.name, KeyID: id}
+		}
+		if c, err = f.aead(id, key); err != nil {
+			return nil, err
+		}
+	}
+	val, err := c.(cipher.AEAD).Open(nil, b[4:sealedHeader], b[sealedHeader:], ad)
+	if err != nil {
+		return nil, &KeyError{File: f.name, KeyID: id}
+	}
+	return val, nil
+}
+
+// keyMAC return HMAC key of keys of file of format ft,
+// nil if keys are not encrypted
+func (f *dbFile) keyMAC(ft format) ([]byte, error) {
+	if ft.flags&flagKeys == 0 {
+		return nil, nil
+	}
+	if f.opts.Keys == nil {
+		return nil, &KeyError{File: f.name, KeyID: ft.keyID}
+	}
+	key, err := f.opts.Keys.Key(ft.keyID)
+	if err != nil {
+		return nil, &KeyError{File: f.name, KeyID: ft.keyID}
+	}
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte("slowpoke keys"))
+	return h.Sum(nil), nil
+}
+
+// storedKey return key stored in file with HMAC key mac
+func storedKey(mac, key []byte) []byte {
+	if mac == nil {
+		return key
+	}
+	h := hmac.New(sha256.New, mac)
+	h.Write(key)
+	return h.Sum(nil)[:16]
+}
+
+// dbKey return key stored in file
+func (f *dbFile) dbKey(key []byte) []byte {
+	return storedKey(f.mac, key)
+}
+
+// keyDB return db of keys: file or db of decrypted keys
+func (f *dbFile) keyDB() *pudge.Db {
+	if f.plain != nil {
+		return f.plain
+	}
+	return f.db
+}
+
+// decryptKeys return sorted keys of stored keys and keep them in db of keys
+// Return *KeyError if key of file is wrong
+func (f *dbFile) decryptKeys(stored [][]byte) ([][]byte, error) {
+	plain, err := openMemory()
+	if err != nil {
+		return nil, err
+	}
+	keys := make([][]byte, 0, len(stored))
+	for _, dk := range stored {
+		var b []byte
+		if err = f.db.Get(dk, &b); err != nil {
+			return nil, err
+		}
+		key, _, err := f.decodeRecord(dk, b)
+		if err != nil {
+			return nil, err
+		}
+		if err = plain.Set(key, []byte{}); err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, k int) bool {
+		return bytes.Compare(keys[i], keys[k]) < 0
+	})
+	f.plain = plain
+	return keys, nil
+}
+
+// checkKey return *KeyError if first value of file can't be decrypted,
+// so wrong key is found on open, file must be locked
+func (f *dbFile) checkKey() error {
+	if f.format.flags&flagCodec == 0 || f.mac != nil {
+		return nil
+	}
+	sorted := f.sortedKeys()
+	if len(sorted) == 0 {
+		return nil
+	}
+	key := sorted[0]
+	if _, err := f.read(key); errors.Is(err, ErrWrongKey) {
+		return err
+	}
+	return nil
+}
+
+// sealOps return ops of journal with encrypted keys and values
+func (f *dbFile) sealOps(ops []txOp) ([]txOp, error) {
+	if !f.encrypted() {
+		return ops, nil
+	}
+	sealed := make([]txOp, len(ops))
+	for i, op := range ops {
+		key, err := f.seal(op.key, nil)
+		if err != nil {
+			return nil, err
+		}
+		val, err := f.seal(op.val, key[:sealedHeader])
+		if err != nil {
+			return nil, err
+		}
+		op.key, op.val, op.sealed = key, val, true
+		sealed[i] = op
+	}
+	return sealed, nil
+}
+
+// unsealOp return op of journal with decrypted key and value
+func (f *dbFile) unsealOp(op txOp) (txOp, error) {
+	key, err := f.unseal(op.key, nil)
+	if err != nil {
+		return op, err
+	}
+	val, err := f.unseal(op.val, op.key[:sealedHeader])
+	if err != nil {
+		return op, err
+	}
+	op.key, op.val, op.sealed = key, val, false
+	return op, nil
+}
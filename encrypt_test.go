@@ -0,0 +1,203 @@
+package slowpoke
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+// contains return true if file contains b
+func contains(t *testing.T, file string, b []byte) bool {
+	data, err := ioutil.ReadFile(file)
+	ch(err, t)
+	return bytes.Contains(data, b)
+}
+
+func TestEncrypt(t *testing.T) {
+	f := "test/TestEncrypt.db"
+	DeleteFile(f)
+	defer DeleteFile(f)
+	keys := NewKeyring(1, bytes.Repeat([]byte{1}, 32))
+	db, err := Open(f, &Options{Keys: keys})
+	ch(err, t)
+	for i := 0; i < 10; i++ {
+		ch(db.Set([]byte(fmt.Sprintf("key%d", i)), []byte(fmt.Sprintf("secret%d", i))), t)
+	}
+	if v, err := db.Get([]byte("key3")); err != nil || string(v) != "secret3" {
+		t.Error("get", string(v), err)
+	}
+	ch(db.Close(), t)
+	if contains(t, f, []byte("secret")) {
+		t.Error("value not encrypted")
+	}
+	if !contains(t, f+".idx", []byte("key3")) {
+		t.Error("key encrypted")
+	}
+
+	// wrong key
+	var ke *KeyError
+	if _, err = Open(f, &Options{Keys: NewKeyring(1, bytes.Repeat([]byte{2}, 32))}); !errors.As(err, &ke) || ke.KeyID != 1 {
+		t.Error("opened with wrong key", err)
+	}
+	if _, err = Open(f, &Options{Keys: NewKeyring(2, bytes.Repeat([]byte{1}, 32))}); !errors.Is(err, ErrWrongKey) {
+		t.Error("opened with unknown key", err)
+	}
+	if _, err = Get(f, []byte("key1")); !errors.Is(err, ErrWrongKey) {
+		t.Error("get without key", err)
+	}
+	ch(Close(f), t)
+
+	// rotation: new values by new key, compaction re-encrypt stored values
+	db, err = Open(f, &Options{Keys: keys})
+	ch(err, t)
+	keys.Add(2, bytes.Repeat([]byte{3}, 16))
+	ch(db.Set([]byte("key10"), []byte("secret10")), t)
+	ch(db.Compact(), t)
+	keys.Remove(1)
+	ch(db.Close(), t)
+	db, err = Open(f, &Options{Keys: keys})
+	ch(err, t)
+	for i := 0; i <= 10; i++ {
+		if v, err := db.Get([]byte(fmt.Sprintf("key%d", i))); err != nil || string(v) != fmt.Sprintf("secret%d", i) {
+			t.Error("get after rotation", i, string(v), err)
+		}
+	}
+	ch(db.Close(), t)
+}
+
+func TestEncryptIndexKeys(t *testing.T) {
+	f := "test/TestEncryptIndexKeys.db"
+	DeleteFile(f)
+	defer DeleteFile(f)
+	keys := NewKeyring(1, bytes.Repeat([]byte{1}, 32))
+	db, err := Open(f, &Options{Keys: keys})
+	ch(err, t)
+	ch(db.Set([]byte("user1"), []byte("secret1")), t)
+	ch(db.CreateIndex("tags", tagsIndex), t)
+	ch(db.Set([]byte("user2"), []byte("secret2")), t)
+	ch(db.Close(), t)
+	// indexed values are keys of index
+	if contains(t, indexFileName(f, "tags")+".idx", []byte("secret")) {
+		t.Error("key of index not encrypted")
+	}
+
+	db, err = Open(f, &Options{Keys: keys})
+	ch(err, t)
+	if kv, err := db.QueryIndex("tags", IndexQuery{Prefix: []byte("secret")}); err != nil || len(kv) != 2 {
+		t.Error("index", kv, err)
+	}
+	ch(db.Close(), t)
+}
+
+func TestEncryptKeys(t *testing.T) {
+	f := "test/TestEncryptKeys.db"
+	DeleteFile(f)
+	defer DeleteFile(f)
+	keys := NewKeyring(7, bytes.Repeat([]byte{1}, 32))
+	opts := &Options{Keys: keys, EncryptKeys: true, Compressor: Flate{}}
+	db, err := Open(f, opts)
+	ch(err, t)
+	ch(db.CreateIndex("tags", tagsIndex), t)
+	for i := 0; i < 10; i++ {
+		ch(db.Set([]byte(fmt.Sprintf("user%d", i)), []byte(fmt.Sprintf("go%d", i))), t)
+	}
+	ch(db.SetWithTTL([]byte("user5"), []byte("go5"), time.Hour), t)
+	ch(db.Close(), t)
+	for _, name := range []string{f, f + ".idx", ttlName(f) + ".idx", indexFileName(f, "tags") + ".idx"} {
+		if contains(t, name, []byte("user")) {
+			t.Error("key not encrypted", name)
+		}
+	}
+
+	// format of file is kept, keys are encrypted without EncryptKeys
+	db, err = Open(f, &Options{Keys: keys})
+	ch(err, t)
+	if has, err := db.Has([]byte("user1")); err != nil || !has {
+		t.Error("has", has, err)
+	}
+	ch(db.Close(), t)
+
+	db, err = Open(f, opts)
+	ch(err, t)
+	if keys, err := db.Keys([]byte("user*"), 3, 0, false); err != nil || len(keys) != 3 || string(keys[0]) != "user9" {
+		t.Error("keys", keys, err)
+	}
+	if ttl, err := db.TTL([]byte("user5")); err != nil || ttl <= 0 {
+		t.Error("ttl", ttl, err)
+	}
+	if kv, err := db.QueryIndex("tags", IndexQuery{Prefix: []byte("go3")}); err != nil || len(kv) != 1 || string(kv[0].Key) != "user3" {
+		t.Error("index", kv, err)
+	}
+	_, err = db.Delete([]byte("user0"))
+	ch(err, t)
+	if cnt, _ := db.Count(); cnt != 9 {
+		t.Error("count", cnt)
+	}
+
+	// rotation of keys
+	keys.Add(8, bytes.Repeat([]byte{2}, 32))
+	ch(db.Compact(), t)
+	ch(db.Close(), t)
+	keys.Remove(7)
+	db, err = Open(f, opts)
+	ch(err, t)
+	if v, err := db.Get([]byte("user9")); err != nil || string(v) != "go9" {
+		t.Error("get after rotation", string(v), err)
+	}
+	if kv, err := db.QueryIndex("tags", IndexQuery{Prefix: []byte("go3")}); err != nil || len(kv) != 1 {
+		t.Error("index after rotation", kv, err)
+	}
+	ch(db.Close(), t)
+
+	if _, err = openFile(f); !errors.Is(err, ErrWrongKey) {
+		t.Error("opened without key", err)
+	}
+
+	// journal of batch is encrypted
+	db, err = Open(f, opts)
+	ch(err, t)
+	fl, err := openFile(f)
+	ch(err, t)
+	ops, err := fl.sealOps([]txOp{{file: f, key: []byte("user20"), val: []byte("go20")}})
+	ch(err, t)
+	j := newJournal(f)
+	j.ops = ops
+	ch(writeJournal(f, j, 0666), t)
+	if contains(t, journalName(f), []byte("user20")) {
+		t.Error("journal not encrypted")
+	}
+	ch(db.Close(), t)
+	db, err = Open(f, opts)
+	ch(err, t)
+	if v, err := db.Get([]byte("user20")); err != nil || string(v) != "go20" {
+		t.Error("journal not replayed", string(v), err)
+	}
+	ch(db.Close(), t)
+}
+
+func TestEncryptMigrate(t *testing.T) {
+	f := "test/TestEncryptMigrate.db"
+	DeleteFile(f)
+	defer DeleteFile(f)
+	ch(Set(f, []byte("a"), []byte("plain")), t)
+	ch(Close(f), t)
+
+	opts := &Options{Keys: NewKeyring(1, bytes.Repeat([]byte{1}, 16)), EncryptKeys: true}
+	db, err := Open(f, opts)
+	ch(err, t)
+	if err = db.Set([]byte("b"), []byte("2")); !errors.Is(err, ErrMigrate) {
+		t.Error("write of not migrated file", err)
+	}
+	ch(db.Migrate(), t)
+	ch(db.Set([]byte("b"), []byte("2")), t)
+	if v, err := db.Get([]byte("a")); err != nil || string(v) != "plain" {
+		t.Error("get after migrate", string(v), err)
+	}
+	ch(db.Close(), t)
+	if contains(t, f, []byte("plain")) {
+		t.Error("not encrypted by migrate")
+	}
+}
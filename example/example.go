@@ -62,18 +62,14 @@ func advanced() {
 	fmt.Println(keys) //[[0 0 0 37] [0 0 0 36]]
 
 	//get key/ values
-	res := slowpoke.Gets(posts, keys)
-	for k, v := range res {
-		if k%2 == 0 {
-			fmt.Print(binary.BigEndian.Uint32(v))
-		} else {
-			var p post
-			json.Unmarshal(v, &p)
-			fmt.Println(p)
-		}
+	res, _ := slowpoke.GetMany(posts, keys)
+	for _, kv := range res {
+		var p post
+		json.Unmarshal(kv.Value, &p)
+		fmt.Println(binary.BigEndian.Uint32(kv.Key), p)
 	}
-	//37{37 Content:37 Category:3}
-	//36{36 Content:36 Category:3}
+	//37 {37 Content:37 Category:3}
+	//36 {36 Content:36 Category:3}
 
 	//free from memory
 	slowpoke.Close(posts)
@@ -0,0 +1,15 @@
+//go:build windows || plan9
+
+package slowpoke
+
+import "os"
+
+// flock is not supported, file is never locked
+func flock(fd *os.File, exclusive bool) error {
+	return nil
+}
+
+// funlock is not supported
+func funlock(fd *os.File) error {
+	return nil
+}
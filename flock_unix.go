@@ -0,0 +1,26 @@
+//go:build !windows && !plan9
+
+package slowpoke
+
+import (
+	"os"
+	"syscall"
+)
+
+// flock lock fd without waiting, return errWouldBlock if fd locked
+func flock(fd *os.File, exclusive bool) error {
+	how := syscall.LOCK_SH
+	if exclusive {
+		how = syscall.LOCK_EX
+	}
+	err := syscall.Flock(int(fd.Fd()), how|syscall.LOCK_NB)
+	if err == syscall.EWOULDBLOCK {
+		return errWouldBlock
+	}
+	return err
+}
+
+// funlock unlock fd
+func funlock(fd *os.File) error {
+	return syscall.Flock(int(fd.Fd()), syscall.LOCK_UN)
+}
@@ -0,0 +1,298 @@
+package slowpoke
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+)
+
+// Value file created by slowpoke starts with header:
+//
+//	8 bytes magic "slowpoke"
+//	2 bytes format version
+//	2 bytes feature flags
+//	4 bytes ID of key of keys (flagKeys)
+//
+// pudge append values after header. File without header (created before
+// version 1 or by pudge) has version 0 without features and is read
+// as before, Migrate rewrite it in current format. Value of file with
+// header is never stored inside header, so file without header whose
+// first value starts with magic is found by its index file.
+// File of newer version or with unknown features is not opened.
+//
+// Features:
+//
+//	flagChecksum - value is stored with 4 bytes CRC32C of key and value at end
+//	flagCodec - value is stored after 1 byte ID of its compressor, 0 - not compressed
+//	flagKeys - keys are encrypted, see encrypt.go
+//
+// Files of expiration time (file + ".ttl") are stored without header.
+
+// formatVersion is version of created files
+const formatVersion = 1
+
+// features of value file
+const (
+	flagChecksum uint16 = 1 << iota
+	flagCodec
+	flagKeys
+)
+
+// knownFlags is all supported features
+const knownFlags = flagChecksum | flagCodec | flagKeys
+
+// headerSize is size of header of value file
+const headerSize = 16
+
+// headerMagic is first bytes of value file with header
+var headerMagic = []byte("slowpoke")
+
+// format of value file
+type format struct {
+	version int
+	flags   uint16
+	keyID   uint32 // ID of key of keys, if flagKeys
+}
+
+// currentFormat is format of created files, see newFormat
+var currentFormat = format{version: formatVersion, flags: flagChecksum | flagCodec}
+
+// ErrFormat is matched by errors.Is for *FormatError
+var ErrFormat = errors.New("slowpoke: unsupported file format")
+
+// FormatError returned on open of file written by newer version
+// of slowpoke: unknown format version or features
+type FormatError struct {
+	File    string
+	Version int
+	Flags   uint16
+}
+
+func (e *FormatError) Error() string {
+	return fmt.Sprintf("slowpoke: %s has format version %d with features %#x, supported version %d with features %#x",
+		e.File, e.Version, e.Flags, formatVersion, knownFlags)
+}
+
+// Unwrap return ErrFormat
+func (e *FormatError) Unwrap() error {
+	return ErrFormat
+}
+
+// ErrCorrupted is matched by errors.Is for *CorruptedError
+var ErrCorrupted = errors.New("slowpoke: value is corrupted")
+
+// CorruptedError returned by reads of value with wrong checksum
+type CorruptedError struct {
+	File   string
+	Key    []byte
+	Offset int64 // offset of value in file, -1 - unknown
+}
+
+func (e *CorruptedError) Error() string {
+	return fmt.Sprintf("slowpoke: value of %q in %s at %d is corrupted", e.Key, e.File, e.Offset)
+}
+
+// Unwrap return ErrCorrupted
+func (e *CorruptedError) Unwrap() error {
+	return ErrCorrupted
+}
+
+// crcTable is CRC32C table
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// checksum return CRC32C of key and value
+func checksum(key, val []byte) uint32 {
+	return crc32.Update(crc32.Checksum(key, crcTable), crcTable, val)
+}
+
+// encodeValue return stored bytes of value
+func (ft format) encodeValue(key, val []byte) []byte {
+	if ft.flags&flagChecksum == 0 {
+		return val
+	}
+	b := make([]byte, len(val)+4)
+	copy(b, val)
+	binary.BigEndian.PutUint32(b[len(val):], checksum(key, val))
+	return b
+}
+
+// decodeValue return value of stored bytes, false if checksum not match
+func (ft format) decodeValue(key, b []byte) ([]byte, bool) {
+	if ft.flags&flagChecksum == 0 {
+		return b, true
+	}
+	if len(b) < 4 {
+		return nil, false
+	}
+	n := len(b) - 4
+	return b[:n], binary.BigEndian.Uint32(b[n:]) == checksum(key, b[:n])
+}
+
+// dataStart return offset of first value in file
+func (ft format) dataStart() int64 {
+	if ft.version == 0 {
+		return 0
+	}
+	return headerSize
+}
+
+// readHeader return format of value file, version 0 if file has no header
+// Return *FormatError if format is not supported
+func readHeader(name string) (format, error) {
+	fd, err := os.Open(name)
+	if err != nil {
+		return format{}, err
+	}
+	defer fd.Close()
+	head := make([]byte, headerSize)
+	if _, err = io.ReadFull(fd, head); err == io.EOF || err == io.ErrUnexpectedEOF {
+		return format{}, nil
+	}
+	if err != nil || !bytes.Equal(head[:len(headerMagic)], headerMagic) {
+		return format{}, err
+	}
+	if legacy, err := valueInHeader(name); err != nil || legacy {
+		return format{}, err
+	}
+	ft := format{
+		version: int(binary.BigEndian.Uint16(head[len(headerMagic):])),
+		flags:   binary.BigEndian.Uint16(head[len(headerMagic)+2:]),
+		keyID:   binary.BigEndian.Uint32(head[len(headerMagic)+4:]),
+	}
+	if ft.version > formatVersion || ft.flags&^knownFlags != 0 {
+		return ft, &FormatError{File: name, Version: ft.version, Flags: ft.flags}
+	}
+	return ft, nil
+}
+
+// errValueInHeader stop reading of index on value stored inside header
+var errValueInHeader = errors.New("slowpoke: value inside header")
+
+// valueInHeader return true if index of file has value stored where
+// header must be, so file has no header
+// readHeader and createHeader decide format of file by it
+func valueInHeader(name string) (bool, error) {
+	_, err := readIndex(name, func(r *indexRecord) error {
+		if !r.del && r.seek < headerSize {
+			return errValueInHeader
+		}
+		return nil
+	})
+	switch {
+	case err == errValueInHeader:
+		return true, nil
+	case os.IsNotExist(err):
+		return false, nil
+	}
+	return false, err
+}
+
+// createHeader write header of format ft in value file
+// if it's missing or empty and return format of file
+// Empty file with values in index (all values are empty) has no header
+func createHeader(name string, mode os.FileMode, ft format) (format, error) {
+	fi, err := os.Stat(name)
+	if err == nil && fi.Size() > 0 {
+		return readHeader(name)
+	}
+	if err != nil && !os.IsNotExist(err) {
+		return format{}, err
+	}
+	if legacy, err := valueInHeader(name); err != nil || legacy {
+		return format{}, err
+	}
+	head := make([]byte, headerSize)
+	copy(head, headerMagic)
+	binary.BigEndian.PutUint16(head[len(headerMagic):], uint16(ft.version))
+	binary.BigEndian.PutUint16(head[len(headerMagic)+2:], ft.flags)
+	binary.BigEndian.PutUint32(head[len(headerMagic)+4:], ft.keyID)
+	fd, err := os.OpenFile(name, os.O_CREATE|os.O_WRONLY, mode)
+	if err != nil {
+		return format{}, err
+	}
+	_, err = fd.WriteAt(head, 0)
+	if e := fd.Close(); err == nil {
+		err = e
+	}
+	return ft, err
+}
+
+// header return format of file, header of new file is created
+func (f *dbFile) header() (format, error) {
+	switch {
+	case f.opts.InMemory:
+		// values in memory don't need checksum
+		return format{flags: flagCodec}, nil
+	case f.opts.ReadOnly:
+		return readHeader(f.name)
+	}
+	ft, err := f.newFormat()
+	if err != nil {
+		return ft, err
+	}
+	return createHeader(f.name, f.opts.FileMode, ft)
+}
+
+// newFormat return format of new file with options of file:
+// current format with encrypted keys by current key if EncryptKeys
+func (f *dbFile) newFormat() (format, error) {
+	ft := currentFormat
+	if f.opts.EncryptKeys && !f.opts.InMemory {
+		id, _, err := f.opts.Keys.CurrentKey()
+		if err != nil {
+			return ft, err
+		}
+		ft.flags |= flagKeys
+		ft.keyID = id
+	}
+	return ft, nil
+}
+
+// corrupted return error of value with wrong checksum, dk is key stored in file
+// Offset of value is read from index file
+func (f *dbFile) corrupted(dk []byte) error {
+	e := &CorruptedError{File: f.name, Key: append([]byte(nil), dk...), Offset: -1}
+	if r, err := liveIndex(f.name); err == nil && r[string(dk)] != nil {
+		e.Offset = int64(r[string(dk)].seek)
+	}
+	return e
+}
+
+// Migrate rewrite file of older format (created by older slowpoke
+// or by pudge) in current format, keys of file opened with EncryptKeys
+// are encrypted by current key. Index files of file are migrated too
+// File is rewritten by compaction, reads and writes work while file migrated
+// Do nothing if file has current format
+func Migrate(file string) error {
+	if err := migrate(file); err != nil {
+		return err
+	}
+	for _, idx := range indexesOf(file) {
+		if err := migrate(idx.file); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// migrate rewrite file in current format if needed
+func migrate(file string) error {
+	f, err := openFile(file)
+	if err != nil {
+		return err
+	}
+	if f.opts.InMemory {
+		return nil
+	}
+	f.RLock()
+	ft := f.format
+	f.RUnlock()
+	if cur, err := f.newFormat(); err != nil || ft == cur {
+		return err
+	}
+	return f.compact()
+}
@@ -0,0 +1,223 @@
+package slowpoke
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/recoilme/pudge"
+)
+
+func TestChecksum(t *testing.T) {
+	f := "test/TestChecksum.db"
+	DeleteFile(f)
+	defer DeleteFile(f)
+	ch(Set(f, []byte("a"), []byte("good")), t)
+	ch(Set(f, []byte("b"), []byte("value")), t)
+	ch(Close(f), t)
+	if v, err := readHeader(f); err != nil || v != currentFormat {
+		t.Error("version of new file", v, err)
+	}
+
+	// flip byte of value of b
+	live, err := liveIndex(f)
+	ch(err, t)
+	seek := int64(live["b"].seek)
+	fd, err := os.OpenFile(f, os.O_RDWR, 0)
+	ch(err, t)
+	_, err = fd.WriteAt([]byte("V"), seek)
+	ch(err, t)
+	fd.Close()
+
+	var ce *CorruptedError
+	if _, err = Get(f, []byte("b")); !errors.As(err, &ce) || string(ce.Key) != "b" || ce.Offset != seek {
+		t.Error("get of corrupted value", err)
+	}
+	if !errors.Is(err, ErrCorrupted) {
+		t.Error("not ErrCorrupted", err)
+	}
+	if v, err := Get(f, []byte("a")); err != nil || string(v) != "good" {
+		t.Error("get of good value", string(v), err)
+	}
+	if _, err = GetMany(f, [][]byte{[]byte("a"), []byte("b")}); !errors.Is(err, ErrCorrupted) {
+		t.Error("get many", err)
+	}
+	if _, err = GetsCtx(context.Background(), f, [][]byte{[]byte("b")}); !errors.Is(err, ErrCorrupted) {
+		t.Error("gets ctx", err)
+	}
+	if res := Gets(f, [][]byte{[]byte("a"), []byte("b")}); len(res) != 2 {
+		t.Error("gets", res)
+	}
+	it := NewIterator(f, nil)
+	for it.Next() {
+		it.Value()
+	}
+	if !errors.Is(it.Close(), ErrCorrupted) {
+		t.Error("iterator", it.Err())
+	}
+
+	r, err := Verify(f)
+	ch(err, t)
+	if len(r.Problems) != 1 || string(r.Problems[0].Key) != "b" {
+		t.Error("verify", r.Problems)
+	}
+	_, err = Repair(f)
+	ch(err, t)
+	if has, _ := Has(f, []byte("b")); has {
+		t.Error("corrupted key not removed")
+	}
+}
+
+func TestLegacyFormat(t *testing.T) {
+	f := "test/TestLegacyFormat.db"
+	DeleteFile(f)
+	defer DeleteFile(f)
+	// file of old version has values without header and checksum
+	db, err := pudge.Open(f, nil)
+	ch(err, t)
+	ch(db.Set([]byte("a"), []byte("old")), t)
+	ch(db.Close(), t)
+
+	if v, err := Get(f, []byte("a")); err != nil || string(v) != "old" {
+		t.Error("get of old value", string(v), err)
+	}
+	ch(Set(f, []byte("b"), []byte("new")), t)
+	ch(Close(f), t)
+	if v, _ := readHeader(f); v != (format{}) {
+		t.Error("version of old file changed", v)
+	}
+	r, err := Verify(f)
+	ch(err, t)
+	if !r.OK() || r.Keys != 2 {
+		t.Error("verify of old file", r.Keys, r.Problems)
+	}
+
+	// compaction write file of current version
+	ch(Compact(f), t)
+	for k, want := range map[string]string{"a": "old", "b": "new"} {
+		if v, err := Get(f, []byte(k)); err != nil || !bytes.Equal(v, []byte(want)) {
+			t.Error("get after compaction", k, string(v), err)
+		}
+	}
+	ch(Close(f), t)
+	if v, _ := readHeader(f); v != currentFormat {
+		t.Error("version after compaction", v)
+	}
+}
+
+func TestLegacyMagic(t *testing.T) {
+	f := "test/TestLegacyMagic.db"
+	DeleteFile(f)
+	defer DeleteFile(f)
+	// first value of file without header starts with magic
+	for _, val := range []string{"slowpoke\x00\x01\x00\x01\x00\x00\x00\x00", "slowpoke\xff\xff\xff\xff\x00\x00\x00\x00"} {
+		DeleteFile(f)
+		db, err := pudge.Open(f, nil)
+		ch(err, t)
+		ch(db.Set([]byte("a"), []byte(val)), t)
+		ch(db.Set([]byte("b"), []byte("old")), t)
+		ch(db.Close(), t)
+		for k, want := range map[string]string{"a": val, "b": "old"} {
+			if v, err := Get(f, []byte(k)); err != nil || string(v) != want {
+				t.Errorf("get of %s %q %v", k, v, err)
+			}
+		}
+		ch(Close(f), t)
+	}
+}
+
+func TestLegacyEmptyValues(t *testing.T) {
+	f := "test/TestLegacyEmptyValues.db"
+	DeleteFile(f)
+	defer DeleteFile(f)
+	// keys without values, value file is empty
+	db, err := pudge.Open(f, nil)
+	ch(err, t)
+	ch(db.Set([]byte("a"), []byte{}), t)
+	ch(db.Set([]byte("b"), []byte{}), t)
+	ch(db.Close(), t)
+
+	for _, k := range []string{"a", "b"} {
+		if v, err := Get(f, []byte(k)); err != nil || len(v) != 0 {
+			t.Errorf("get of %s %q %v", k, v, err)
+		}
+	}
+	ch(Set(f, []byte("new"), []byte("hello")), t)
+	ch(CloseAll(), t)
+	if ft, _ := readHeader(f); ft != (format{}) {
+		t.Error("header written in old file", ft)
+	}
+	if v, err := Get(f, []byte("new")); err != nil || string(v) != "hello" {
+		t.Errorf("get after reopen %q %v", v, err)
+	}
+	res, err := GetMany(f, [][]byte{[]byte("a"), []byte("new")})
+	ch(err, t)
+	if len(res) != 2 || !res[0].Found || string(res[1].Value) != "hello" {
+		t.Errorf("get many %+v", res)
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	f := "test/TestMigrate.db"
+	DeleteFile(f)
+	defer DeleteFile(f)
+	db, err := pudge.Open(f, nil)
+	ch(err, t)
+	ch(db.Set([]byte("a"), []byte("1")), t)
+	ch(db.Set([]byte("b"), []byte("2")), t)
+	ch(db.Close(), t)
+
+	ch(Migrate(f), t)
+	ch(Set(f, []byte("c"), []byte("3")), t)
+	if cnt, _ := Count(f); cnt != 3 {
+		t.Error("count after migrate", cnt)
+	}
+	ch(Close(f), t)
+	if v, _ := readHeader(f); v != currentFormat {
+		t.Error("format after migrate", v)
+	}
+	fi, err := os.Stat(f)
+	ch(err, t)
+	// file of current format is not rewritten
+	ch(Migrate(f), t)
+	ch(Close(f), t)
+	if fi2, _ := os.Stat(f); !fi2.ModTime().Equal(fi.ModTime()) || fi2.Size() != fi.Size() {
+		t.Error("file of current format rewritten")
+	}
+	if v, err := Get(f, []byte("a")); err != nil || string(v) != "1" {
+		t.Error("get after migrate", string(v), err)
+	}
+	ch(Close(f), t)
+}
+
+func TestFutureFormat(t *testing.T) {
+	f := "test/TestFutureFormat.db"
+	DeleteFile(f)
+	defer DeleteFile(f)
+	ch(Set(f, []byte("a"), []byte("1")), t)
+	ch(Close(f), t)
+
+	for _, head := range [][]byte{{0, formatVersion + 1, 0, 0}, {0, formatVersion, 0x80, 0}} {
+		fd, err := os.OpenFile(f, os.O_RDWR, 0)
+		ch(err, t)
+		_, err = fd.WriteAt(head, int64(len(headerMagic)))
+		ch(err, t)
+		fd.Close()
+
+		var fe *FormatError
+		if _, err = Get(f, []byte("a")); !errors.As(err, &fe) || fe.File != f {
+			t.Error("opened file of unknown format", head, err)
+		}
+		if !errors.Is(err, ErrFormat) {
+			t.Error("not ErrFormat", err)
+		}
+		if _, err = Verify(f); !errors.Is(err, ErrFormat) {
+			t.Error("verify", err)
+		}
+		if err = Migrate(f); !errors.Is(err, ErrFormat) {
+			t.Error("migrate", err)
+		}
+	}
+}
@@ -1,6 +1,6 @@
 module github.com/recoilme/slowpoke
 
-go 1.13
+go 1.18
 
 require (
 	github.com/boltdb/bolt v1.3.1
@@ -0,0 +1,106 @@
+package slowpoke
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"os"
+)
+
+// Index file of pudge (file + ".idx") is a log of records:
+//
+//	1 byte version (0)
+//	1 byte command (0-set, 1-delete)
+//	4 bytes seek of value in value file
+//	4 bytes size of value
+//	4 bytes timestamp
+//	2 bytes size of key
+//	key
+//
+// Record of existing key is rewritten in place, delete is appended.
+
+// indexHeaderSize is size of index record without key
+const indexHeaderSize = 16
+
+// indexRecord is a record of index file
+type indexRecord struct {
+	del  bool
+	seek uint32
+	size uint32
+	time uint32
+	key  []byte
+	pos  int64 // position of record in index file
+}
+
+// recordSize return size of record in index file
+func (r *indexRecord) recordSize() int64 {
+	return int64(indexHeaderSize + len(r.key))
+}
+
+// encode return bytes of record in index file
+func (r *indexRecord) encode() []byte {
+	b := make([]byte, indexHeaderSize, indexHeaderSize+len(r.key))
+	if r.del {
+		b[1] = 1
+	}
+	binary.BigEndian.PutUint32(b[2:], r.seek)
+	binary.BigEndian.PutUint32(b[6:], r.size)
+	binary.BigEndian.PutUint32(b[10:], r.time)
+	binary.BigEndian.PutUint16(b[14:], uint16(len(r.key)))
+	return append(b, r.key...)
+}
+
+// readIndex call fn for every complete record of index file
+// Return size of complete records, it's less then file size if tail is torn
+// Record with unknown version or command is treated as torn tail
+func readIndex(file string, fn func(r *indexRecord) error) (int64, error) {
+	fd, err := os.Open(file + ".idx")
+	if err != nil {
+		return 0, err
+	}
+	defer fd.Close()
+	rd := bufio.NewReader(fd)
+	var pos int64
+	head := make([]byte, indexHeaderSize)
+	for {
+		if _, err = io.ReadFull(rd, head); err != nil {
+			break
+		}
+		if head[0] != 0 || head[1] > 1 {
+			break
+		}
+		r := &indexRecord{
+			del:  head[1] == 1,
+			seek: binary.BigEndian.Uint32(head[2:]),
+			size: binary.BigEndian.Uint32(head[6:]),
+			time: binary.BigEndian.Uint32(head[10:]),
+			key:  make([]byte, binary.BigEndian.Uint16(head[14:])),
+			pos:  pos,
+		}
+		if _, err = io.ReadFull(rd, r.key); err != nil {
+			break
+		}
+		if err = fn(r); err != nil {
+			return pos, err
+		}
+		pos += r.recordSize()
+	}
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		err = nil
+	}
+	return pos, err
+}
+
+// liveIndex return records of not deleted keys
+func liveIndex(file string) (map[string]*indexRecord, error) {
+	live := make(map[string]*indexRecord)
+	_, err := readIndex(file, func(r *indexRecord) error {
+		if r.del {
+			delete(live, string(r.key))
+		} else {
+			live[string(r.key)] = r
+		}
+		return nil
+	})
+	return live, err
+}
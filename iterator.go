@@ -0,0 +1,340 @@
+package slowpoke
+
+import (
+	"bytes"
+	"sort"
+
+	"github.com/recoilme/pudge"
+)
+
+// Sorted keys of file are kept in memory (key bytes are shared with pudge),
+// so iterator find next key by binary search and never hold position,
+// concurrent writes don't break iteration.
+// New and removed keys are collected and merged into sorted keys on first
+// read after write, so writes of new keys don't move sorted keys.
+// Merge make new slice, so returned sorted keys are never changed.
+
+// loadKeys read sorted keys of file
+func (f *dbFile) loadKeys() error {
+	keys, err := f.db.Keys(nil, 0, 0, true)
+	if err == nil && f.mac != nil {
+		keys, err = f.decryptKeys(keys)
+	}
+	if err != nil {
+		return err
+	}
+	f.keysMu.Lock()
+	f.sorted, f.added, f.removed = keys, nil, nil
+	f.keysMu.Unlock()
+	return nil
+}
+
+// addKey add new key to sorted keys, key must not exist
+func (f *dbFile) addKey(key []byte) {
+	f.keysMu.Lock()
+	defer f.keysMu.Unlock()
+	if _, ok := f.removed[string(key)]; ok {
+		// removed key is still in sorted or added keys
+		delete(f.removed, string(key))
+		return
+	}
+	f.added = append(f.added, append([]byte(nil), key...))
+}
+
+// removeKey remove existing key from sorted keys
+func (f *dbFile) removeKey(key []byte) {
+	f.keysMu.Lock()
+	defer f.keysMu.Unlock()
+	if f.removed == nil {
+		f.removed = make(map[string]struct{})
+	}
+	f.removed[string(key)] = struct{}{}
+}
+
+// sortedKeys return sorted keys of file, result must not be modified
+func (f *dbFile) sortedKeys() [][]byte {
+	f.keysMu.RLock()
+	keys, merged := f.sorted, len(f.added) == 0 && len(f.removed) == 0
+	f.keysMu.RUnlock()
+	if merged {
+		return keys
+	}
+	f.keysMu.Lock()
+	defer f.keysMu.Unlock()
+	if len(f.added) == 0 && len(f.removed) == 0 {
+		return f.sorted
+	}
+	sort.Slice(f.added, func(i, k int) bool {
+		return bytes.Compare(f.added[i], f.added[k]) < 0
+	})
+	keys = make([][]byte, 0, len(f.sorted)+len(f.added)-len(f.removed))
+	add := func(key []byte) {
+		if _, ok := f.removed[string(key)]; ok {
+			return
+		}
+		if n := len(keys); n > 0 && bytes.Equal(keys[n-1], key) {
+			return
+		}
+		keys = append(keys, key)
+	}
+	i, k := 0, 0
+	for i < len(f.sorted) || k < len(f.added) {
+		if k == len(f.added) || (i < len(f.sorted) && bytes.Compare(f.sorted[i], f.added[k]) <= 0) {
+			add(f.sorted[i])
+			i++
+		} else {
+			add(f.added[k])
+			k++
+		}
+	}
+	f.sorted, f.added, f.removed = keys, nil, nil
+	return keys
+}
+
+// after return first key greater than key (or equal if inclusive),
+// nil key - first key; return nil if not found
+func (f *dbFile) after(key []byte, inclusive bool) []byte {
+	sorted := f.sortedKeys()
+	i := 0
+	if key != nil {
+		i = sort.Search(len(sorted), func(i int) bool {
+			c := bytes.Compare(sorted[i], key)
+			return c > 0 || (inclusive && c == 0)
+		})
+	}
+	if i < len(sorted) {
+		return sorted[i]
+	}
+	return nil
+}
+
+// before return last key less than key (or equal if inclusive),
+// nil key - last key; return nil if not found
+func (f *dbFile) before(key []byte, inclusive bool) []byte {
+	sorted := f.sortedKeys()
+	i := len(sorted)
+	if key != nil {
+		i = sort.Search(len(sorted), func(i int) bool {
+			c := bytes.Compare(sorted[i], key)
+			return c > 0 || (!inclusive && c == 0)
+		})
+	}
+	if i > 0 {
+		return sorted[i-1]
+	}
+	return nil
+}
+
+// IteratorOptions limit keys of Iterator
+type IteratorOptions struct {
+	Prefix []byte // only keys with prefix
+	Start  []byte // first key, included
+	End    []byte // last key, not included
+}
+
+// iterator position
+const (
+	iterStart = iota // not positioned, Next move to first key, Prev to last
+	iterValid        // on key
+	iterDone         // moved past first or last key
+)
+
+// Iterator walk keys of file in order, value is loaded on demand
+// Iterator is not thread-safe, but file may be changed while iterating:
+// iterator see keys added after current one and skip removed
+//
+//	it := slowpoke.NewIterator(file, &slowpoke.IteratorOptions{Prefix: []byte("post:")})
+//	defer it.Close()
+//	for it.Next() {
+//		fmt.Println(string(it.Key()), string(it.Value()))
+//	}
+//	if err := it.Err(); err != nil {
+//		...
+//	}
+type Iterator struct {
+	file   string
+	lower  []byte // first key, included
+	upper  []byte // last key, not included
+	prefix []byte
+	pos    int
+	key    []byte
+	val    []byte
+	loaded bool
+	err    error
+	view   *View // iterator of view, if not nil
+}
+
+// NewIterator return iterator over keys of file, opts may be nil
+// Iterator is not positioned, use Next, Prev or Seek
+func NewIterator(file string, opts *IteratorOptions) *Iterator {
+	it := &Iterator{file: file}
+	if opts == nil {
+		return it
+	}
+	it.lower, it.upper, it.prefix = opts.Start, opts.End, opts.Prefix
+	if it.prefix != nil {
+		if bytes.Compare(it.prefix, it.lower) > 0 {
+			it.lower = it.prefix
+		}
+		if end := prefixEnd(it.prefix); end != nil && (it.upper == nil || bytes.Compare(end, it.upper) < 0) {
+			it.upper = end
+		}
+	}
+	return it
+}
+
+// prefixEnd return first key greater than all keys with prefix,
+// nil if no such key
+func prefixEnd(prefix []byte) []byte {
+	end := append([]byte(nil), prefix...)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return end[:i+1]
+		}
+	}
+	return nil
+}
+
+// Seek move iterator to first key greater or equal to key
+// Return false if no such key
+func (it *Iterator) Seek(key []byte) bool {
+	if bytes.Compare(key, it.lower) < 0 {
+		key = it.lower
+	}
+	return it.move(key, true, true)
+}
+
+// Next move iterator to next key, first call move to first key
+// Return false if no more keys
+func (it *Iterator) Next() bool {
+	switch it.pos {
+	case iterDone:
+		return false
+	case iterValid:
+		return it.move(it.key, false, true)
+	}
+	return it.move(it.lower, true, true)
+}
+
+// Prev move iterator to previous key, first call move to last key
+// Return false if no more keys
+func (it *Iterator) Prev() bool {
+	switch it.pos {
+	case iterDone:
+		return false
+	case iterValid:
+		return it.move(it.key, false, false)
+	}
+	return it.move(it.upper, false, false)
+}
+
+// move iterator to key after (forward) or before from, skip expired keys
+func (it *Iterator) move(from []byte, inclusive, forward bool) bool {
+	it.pos, it.key, it.val, it.loaded = iterDone, nil, nil, false
+	if it.err != nil {
+		return false
+	}
+	if it.view != nil {
+		return it.moveView(from, inclusive, forward)
+	}
+	f, err := openFile(it.file)
+	if err != nil {
+		it.err = err
+		return false
+	}
+	for {
+		var key []byte
+		if forward {
+			key = f.after(from, inclusive)
+		} else {
+			key = f.before(from, inclusive)
+		}
+		if key == nil || !it.inRange(key) {
+			return false
+		}
+		if !f.expired(key) {
+			it.pos, it.key = iterValid, key
+			return true
+		}
+		from, inclusive = key, false
+	}
+}
+
+// moveView move iterator of view, expired keys are skipped by view
+func (it *Iterator) moveView(from []byte, inclusive, forward bool) bool {
+	var key []byte
+	if forward {
+		key, it.err = it.view.after(from, inclusive)
+	} else {
+		key, it.err = it.view.before(from, inclusive)
+	}
+	if key == nil || !it.inRange(key) {
+		return false
+	}
+	it.pos, it.key = iterValid, key
+	return true
+}
+
+// inRange return true if key within iterator bounds
+func (it *Iterator) inRange(key []byte) bool {
+	if it.lower != nil && bytes.Compare(key, it.lower) < 0 {
+		return false
+	}
+	if it.upper != nil && bytes.Compare(key, it.upper) >= 0 {
+		return false
+	}
+	return it.prefix == nil || bytes.HasPrefix(key, it.prefix)
+}
+
+// Valid return true if iterator is on key
+func (it *Iterator) Valid() bool {
+	return it.pos == iterValid
+}
+
+// Key return current key or nil
+// Key must not be modified
+func (it *Iterator) Key() []byte {
+	return it.key
+}
+
+// Value return value of current key, loaded on first call
+// Return nil if key was removed after iterator moved on it or on error (see Err)
+func (it *Iterator) Value() []byte {
+	if it.pos != iterValid || it.loaded {
+		return it.val
+	}
+	it.loaded = true
+	if it.view != nil {
+		val, err := it.view.Get(it.key)
+		if err != nil && err != pudge.ErrKeyNotFound {
+			it.err = err
+		}
+		it.val = val
+		return val
+	}
+	f, err := openFile(it.file)
+	if err != nil {
+		it.err = err
+		return nil
+	}
+	f.RLock()
+	defer f.RUnlock()
+	val, err := f.get(it.key)
+	if err != nil && err != pudge.ErrKeyNotFound {
+		it.err = err
+	}
+	it.val = val
+	return val
+}
+
+// Err return first error of iterator
+func (it *Iterator) Err() error {
+	return it.err
+}
+
+// Close release iterator, it can't be used after close
+func (it *Iterator) Close() error {
+	it.pos, it.key, it.val = iterDone, nil, nil
+	return it.err
+}
@@ -0,0 +1,150 @@
+package slowpoke
+
+import (
+	"encoding/binary"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func iterKeys(it *Iterator, next func() bool) string {
+	s := ""
+	for next() {
+		s += string(it.Key()) + ":" + string(it.Value()) + " "
+	}
+	return s
+}
+
+func TestIterator(t *testing.T) {
+	f := "test/TestIterator.db"
+	DeleteFile(f)
+	defer Close(f)
+	for _, k := range []string{"b1", "a2", "a1", "c1", "b2", "b3"} {
+		ch(Set(f, []byte(k), []byte(k)), t)
+	}
+	Delete(f, []byte("b2"))
+
+	it := NewIterator(f, nil)
+	if s := iterKeys(it, it.Next); s != "a1:a1 a2:a2 b1:b1 b3:b3 c1:c1 " {
+		t.Error("next", s)
+	}
+	it = NewIterator(f, nil)
+	if s := iterKeys(it, it.Prev); s != "c1:c1 b3:b3 b1:b1 a2:a2 a1:a1 " {
+		t.Error("prev", s)
+	}
+	if it.Next() || it.Valid() {
+		t.Error("moved after end")
+	}
+	ch(it.Close(), t)
+
+	it = NewIterator(f, &IteratorOptions{Prefix: []byte("b")})
+	if s := iterKeys(it, it.Next); s != "b1:b1 b3:b3 " {
+		t.Error("prefix", s)
+	}
+	it = NewIterator(f, &IteratorOptions{Prefix: []byte("b")})
+	if s := iterKeys(it, it.Prev); s != "b3:b3 b1:b1 " {
+		t.Error("prefix prev", s)
+	}
+	it = NewIterator(f, &IteratorOptions{Start: []byte("a2"), End: []byte("b3")})
+	if s := iterKeys(it, it.Next); s != "a2:a2 b1:b1 " {
+		t.Error("range", s)
+	}
+
+	it = NewIterator(f, nil)
+	if !it.Seek([]byte("b2")) || string(it.Key()) != "b3" {
+		t.Error("seek", string(it.Key()))
+	}
+	if !it.Prev() || string(it.Key()) != "b1" {
+		t.Error("prev after seek", string(it.Key()))
+	}
+	if it.Seek([]byte("d")) {
+		t.Error("seek after last key")
+	}
+	ch(it.Err(), t)
+}
+
+func TestIteratorChanges(t *testing.T) {
+	f := "test/TestIteratorChanges.db"
+	DeleteFile(f)
+	defer Close(f)
+	defer clock.stop()()
+	for i := 0; i < 100; i++ {
+		k := []byte(fmt.Sprintf("%03d", i))
+		ch(Set(f, k, k), t)
+	}
+	it := NewIterator(f, nil)
+	defer it.Close()
+	cnt := 0
+	for it.Next() {
+		cnt++
+		i := cnt - 1
+		switch {
+		case i == 10:
+			// removed key after current is skipped
+			Delete(f, []byte("011"))
+			// new key after current is visible
+			ch(Set(f, []byte("0105"), nil), t)
+		case i == 20:
+			ch(SetWithTTL(f, []byte("050"), nil, time.Second), t)
+			clock.add(time.Minute)
+		}
+	}
+	ch(it.Err(), t)
+	if cnt != 99 {
+		t.Error("wrong count", cnt)
+	}
+
+	// value loaded lazily
+	it = NewIterator(f, nil)
+	it.Next()
+	ch(Set(f, it.Key(), []byte("new")), t)
+	if string(it.Value()) != "new" {
+		t.Error("value not lazy", string(it.Value()))
+	}
+}
+
+func TestIteratorMerge(t *testing.T) {
+	f := "test/TestIteratorMerge.db"
+	DeleteFile(f)
+	defer Close(f)
+	for _, k := range []string{"c", "a", "e"} {
+		ch(Set(f, []byte(k), nil), t)
+	}
+	it := NewIterator(f, nil)
+	if s := iterKeys(it, it.Next); s != "a: c: e: " {
+		t.Error("first read", s)
+	}
+	// new, overwritten, removed and added again keys between reads
+	for _, k := range []string{"d", "b", "d", "a"} {
+		ch(Set(f, []byte(k), nil), t)
+	}
+	Delete(f, []byte("c"))
+	Delete(f, []byte("b"))
+	ch(Set(f, []byte("c"), nil), t)
+	Delete(f, []byte("e"))
+	it = NewIterator(f, nil)
+	if s := iterKeys(it, it.Prev); s != "d: c: a: " {
+		t.Error("after writes", s)
+	}
+}
+
+// BenchmarkSetDesc store new keys in descending order,
+// time of one Set must not grow with count of keys
+func BenchmarkSetDesc(b *testing.B) {
+	f := "test/BenchmarkSetDesc.db"
+	DeleteFile(f)
+	defer DeleteFile(f)
+	db, err := Open(f, &Options{InMemory: true})
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer db.Close()
+	key := make([]byte, 8)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		binary.BigEndian.PutUint64(key, uint64(b.N-i))
+		if err = db.Set(key, nil); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,283 @@
+package slowpoke
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/recoilme/pudge"
+)
+
+// Journal (file + ".wal") contains batch of writes not yet applied to file.
+// Batch over several files store the same journal near every file.
+// First file (in sorted order) is primary - batch committed when journal
+// of primary file written. Journals of other files written before
+// and removed before primary journal, so journal without journal of primary
+// means batch was not committed.
+
+// errBrokenJournal returned on decoding of partial or damaged journal
+var errBrokenJournal = errors.New("slowpoke: broken journal")
+
+// journalMagic is first bytes of journal file
+var journalMagic = []byte("spwal1")
+
+// txOp is a buffered write of transaction or batch
+type txOp struct {
+	file   string
+	key    []byte
+	val    []byte
+	del    bool
+	expire int64 // expiration time of key, unix nano, 0 - never
+	sealed bool  // key and val are encrypted
+}
+
+// journal is a batch of writes
+type journal struct {
+	id      []byte
+	primary string
+	ops     []txOp
+}
+
+// newJournal return journal with unique id
+func newJournal(primary string) *journal {
+	id := make([]byte, 16)
+	rand.Read(id)
+	return &journal{id: id, primary: primary}
+}
+
+// journalName return name of journal file
+func journalName(file string) string {
+	return file + ".wal"
+}
+
+// apply write ops of file and sync it, file must be locked
+func (f *dbFile) apply(ops []txOp) (err error) {
+	for _, op := range ops {
+		if op.file != f.name {
+			continue
+		}
+		if op.sealed {
+			if op, err = f.unsealOp(op); err != nil {
+				return err
+			}
+		}
+		if op.del {
+			err = f.delete(op.key)
+			if err == pudge.ErrKeyNotFound {
+				err = nil
+			}
+		} else {
+			err = f.setWithTTL(op.key, op.val, op.expire)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return f.sync()
+}
+
+// replayJournal apply journal of file if batch was committed
+// Primary file open other files of batch, so they replay own journals
+func (f *dbFile) replayJournal() error {
+	// tmp journal is never committed
+	os.Remove(journalName(f.name) + ".tmp")
+	j, err := readJournal(f.name)
+	if err == errBrokenJournal {
+		// broken journal never was renamed from tmp
+		return removeJournal(f.name)
+	}
+	if j == nil || err != nil {
+		return err
+	}
+	if j.primary != f.name {
+		pj, err := readJournal(j.primary)
+		if err != nil && err != errBrokenJournal {
+			return err
+		}
+		if pj == nil || !bytes.Equal(pj.id, j.id) {
+			// not committed
+			return removeJournal(f.name)
+		}
+		if err = f.apply(j.ops); err != nil {
+			return err
+		}
+		return removeJournal(f.name)
+	}
+	if err = f.apply(j.ops); err != nil {
+		return err
+	}
+	replayed := map[string]bool{f.name: true}
+	for _, op := range j.ops {
+		if replayed[op.file] {
+			continue
+		}
+		replayed[op.file] = true
+		oj, err := readJournal(op.file)
+		if err != nil && err != errBrokenJournal {
+			return err
+		}
+		if oj != nil && bytes.Equal(oj.id, j.id) {
+			if _, err = openFile(op.file); err != nil {
+				return err
+			}
+		}
+	}
+	return removeJournal(f.name)
+}
+
+// writeJournal store journal near file with sync, mode is mode of new journal
+// Journal written in tmp file and renamed, so it's never partial
+//
+// Journal format:
+//
+//	magic "spwal1"
+//	16 bytes batch id
+//	uvarint size of primary file name, primary file name
+//	for every op: 1 byte op (0-set,1-delete,2-set with expiration,
+//	+4 if key and val are encrypted by keys of file),
+//	file, key, val (all with uvarint size), 8 bytes expiration time for op 2
+//	4 bytes crc32 of all previous bytes
+func writeJournal(file string, j *journal, mode os.FileMode) error {
+	buf := new(bytes.Buffer)
+	buf.Write(journalMagic)
+	buf.Write(j.id)
+	writeBytes(buf, []byte(j.primary))
+	for _, op := range j.ops {
+		var t byte
+		switch {
+		case op.del:
+			t = 1
+		case op.expire != 0:
+			t = 2
+		}
+		if op.sealed {
+			t |= 4
+		}
+		buf.WriteByte(t)
+		writeBytes(buf, []byte(op.file))
+		writeBytes(buf, op.key)
+		writeBytes(buf, op.val)
+		if op.expire != 0 && !op.del {
+			binary.Write(buf, binary.BigEndian, op.expire)
+		}
+	}
+	binary.Write(buf, binary.BigEndian, crc32.ChecksumIEEE(buf.Bytes()))
+
+	name := journalName(file)
+	fd, err := os.OpenFile(name+".tmp", os.O_CREATE|os.O_TRUNC|os.O_WRONLY, mode)
+	if err != nil {
+		return err
+	}
+	_, err = fd.Write(buf.Bytes())
+	if err == nil {
+		err = fd.Sync()
+	}
+	if e := fd.Close(); err == nil {
+		err = e
+	}
+	if err == nil {
+		err = os.Rename(name+".tmp", name)
+	}
+	if err != nil {
+		os.Remove(name + ".tmp")
+		return err
+	}
+	syncDir(name)
+	return nil
+}
+
+// readJournal return journal of file or nil if not exists
+func readJournal(file string) (*journal, error) {
+	b, err := ioutil.ReadFile(journalName(file))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return decodeJournal(b)
+}
+
+// removeJournal remove journal of file and not renamed tmp journal if any
+func removeJournal(file string) error {
+	name := journalName(file)
+	os.Remove(name + ".tmp")
+	err := os.Remove(name)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// decodeJournal return journal from bytes
+func decodeJournal(b []byte) (*journal, error) {
+	if len(b) < len(journalMagic)+16+4 || !bytes.Equal(b[:len(journalMagic)], journalMagic) {
+		return nil, errBrokenJournal
+	}
+	body := b[:len(b)-4]
+	if crc32.ChecksumIEEE(body) != binary.BigEndian.Uint32(b[len(b)-4:]) {
+		return nil, errBrokenJournal
+	}
+	buf := bytes.NewBuffer(body[len(journalMagic):])
+	j := &journal{id: buf.Next(16)}
+	primary, err := readBytes(buf)
+	if err != nil {
+		return nil, errBrokenJournal
+	}
+	j.primary = string(primary)
+	for buf.Len() > 0 {
+		t, _ := buf.ReadByte()
+		var fields [3][]byte
+		for i := range fields {
+			if fields[i], err = readBytes(buf); err != nil {
+				return nil, errBrokenJournal
+			}
+		}
+		op := txOp{file: string(fields[0]), key: fields[1], val: fields[2], sealed: t&4 != 0}
+		t &^= 4
+		op.del = t == 1
+		if t == 2 {
+			if buf.Len() < 8 {
+				return nil, errBrokenJournal
+			}
+			op.expire = int64(binary.BigEndian.Uint64(buf.Next(8)))
+		}
+		j.ops = append(j.ops, op)
+	}
+	return j, nil
+}
+
+// writeBytes write uvarint size and bytes
+func writeBytes(buf *bytes.Buffer, b []byte) {
+	var size [binary.MaxVarintLen64]byte
+	buf.Write(size[:binary.PutUvarint(size[:], uint64(len(b)))])
+	buf.Write(b)
+}
+
+// readBytes read bytes written by writeBytes
+func readBytes(buf *bytes.Buffer) ([]byte, error) {
+	size, err := binary.ReadUvarint(buf)
+	if err != nil {
+		return nil, err
+	}
+	if size > uint64(buf.Len()) {
+		return nil, errors.New("slowpoke: unexpected end of data")
+	}
+	return append([]byte{}, buf.Next(int(size))...), nil
+}
+
+// syncDir flush directory of file, so renames will survive crash
+// errors ignored - not all systems support sync of directory
+func syncDir(file string) {
+	d, err := os.Open(filepath.Dir(file))
+	if err != nil {
+		return
+	}
+	d.Sync()
+	d.Close()
+}
@@ -0,0 +1,155 @@
+package slowpoke
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Opened file is locked by flock of file + ".lock": exclusive lock
+// for writes and shared for read-only, so other processes can't write it
+// at the same time. Lock file contains PID of last process locked it.
+// DeleteFile remove files under exclusive lock, process waited for lock
+// of removed lock file lock new one.
+
+// ErrLocked is matched by errors.Is for *LockError
+var ErrLocked = errors.New("slowpoke: file is locked by other process")
+
+// LockError returned on open of file locked by other process
+type LockError struct {
+	File string
+	PID  int // process locked file last, 0 - unknown
+}
+
+func (e *LockError) Error() string {
+	return fmt.Sprintf("slowpoke: %s is locked by process %d", e.File, e.PID)
+}
+
+// Unwrap return ErrLocked
+func (e *LockError) Unwrap() error {
+	return ErrLocked
+}
+
+// errWouldBlock returned by flock if file locked
+var errWouldBlock = errors.New("slowpoke: lock would block")
+
+// lockRetry is interval of attempts to lock file
+var lockRetry = 50 * time.Millisecond
+
+// lockName return name of lock file
+func lockName(file string) string {
+	return file + ".lock"
+}
+
+// acquireLock lock file, wait up to Timeout if file locked by other process
+// Read-only file must exist, so lock file of missing file is not created
+func (f *dbFile) acquireLock() error {
+	if f.opts.InMemory {
+		return nil
+	}
+	if f.opts.ReadOnly {
+		if _, err := os.Stat(f.name); err != nil {
+			return err
+		}
+	} else if err := os.MkdirAll(filepath.Dir(f.name), f.opts.DirMode); err != nil {
+		return err
+	}
+	deadline := time.Now().Add(f.opts.Timeout)
+	for {
+		fd, readOnly, err := f.openLock()
+		if err != nil {
+			return err
+		}
+		err = flock(fd, !f.opts.ReadOnly)
+		switch {
+		case err == nil && !sameFile(fd, lockName(f.name)):
+			// lock file removed by DeleteFile while we wait, lock new one
+			fd.Close()
+			continue
+		case err == errWouldBlock && time.Now().Before(deadline):
+			fd.Close()
+			time.Sleep(lockRetry)
+			continue
+		case err == errWouldBlock:
+			pid := readPID(fd)
+			fd.Close()
+			return &LockError{File: f.name, PID: pid}
+		case err != nil:
+			fd.Close()
+			return err
+		}
+		if !readOnly {
+			fd.Truncate(0)
+			fd.WriteAt([]byte(strconv.Itoa(os.Getpid())), 0)
+		}
+		f.lock = fd
+		return nil
+	}
+}
+
+// openLock open lock file, existing lock file of read-only file
+// is opened read-only, so its write permission is not needed
+func (f *dbFile) openLock() (fd *os.File, readOnly bool, err error) {
+	if f.opts.ReadOnly {
+		fd, err = os.Open(lockName(f.name))
+		if !os.IsNotExist(err) {
+			return fd, true, err
+		}
+	}
+	fd, err = os.OpenFile(lockName(f.name), os.O_CREATE|os.O_RDWR, f.opts.FileMode)
+	return fd, false, err
+}
+
+// sameFile return true if fd is opened file name, it's not removed or replaced
+func sameFile(fd *os.File, name string) bool {
+	fi, err := fd.Stat()
+	if err != nil {
+		return false
+	}
+	ni, err := os.Stat(name)
+	return err == nil && os.SameFile(fi, ni)
+}
+
+// lockDeleted lock closed file for removing its files, return func to unlock it
+// Return *LockError if file locked by other process
+// Lock file of missing file is not created
+func lockDeleted(file string) (unlock func() error, err error) {
+	files.RLock()
+	lf := &dbFile{name: file, opts: files.opts[file].withDefaults()}
+	files.RUnlock()
+	lf.opts.ReadOnly, lf.opts.Timeout = false, 0
+	_, err = os.Stat(file)
+	_, lerr := os.Stat(lockName(file))
+	if os.IsNotExist(err) && os.IsNotExist(lerr) {
+		return lf.releaseLock, nil
+	}
+	if err = lf.acquireLock(); err != nil {
+		return nil, err
+	}
+	return lf.releaseLock, nil
+}
+
+// releaseLock unlock file if locked
+func (f *dbFile) releaseLock() error {
+	if f.lock == nil {
+		return nil
+	}
+	err := funlock(f.lock)
+	if e := f.lock.Close(); err == nil {
+		err = e
+	}
+	f.lock = nil
+	return err
+}
+
+// readPID return PID stored in lock file or 0
+func readPID(fd *os.File) int {
+	b := make([]byte, 32)
+	n, _ := fd.ReadAt(b, 0)
+	pid, _ := strconv.Atoi(strings.TrimSpace(string(b[:n])))
+	return pid
+}
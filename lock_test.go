@@ -0,0 +1,112 @@
+//go:build !windows && !plan9
+
+package slowpoke
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// lockByOther lock file as other process, return func to unlock it
+func lockByOther(t *testing.T, file string, exclusive bool, pid int) func() {
+	fd, err := os.OpenFile(lockName(file), os.O_CREATE|os.O_RDWR, 0666)
+	ch(err, t)
+	ch(flock(fd, exclusive), t)
+	fd.Truncate(0)
+	fd.WriteAt([]byte(strconv.Itoa(pid)), 0)
+	return func() {
+		funlock(fd)
+		fd.Close()
+	}
+}
+
+func TestLock(t *testing.T) {
+	f := "test/TestLock.db"
+	DeleteFile(f)
+	defer DeleteFile(f)
+	ch(Set(f, []byte("a"), []byte("1")), t)
+
+	// opened file is locked for others
+	fd, err := os.OpenFile(lockName(f), os.O_RDWR, 0)
+	ch(err, t)
+	if err = flock(fd, false); err != errWouldBlock {
+		t.Error("file not locked", err)
+	}
+	fd.Close()
+	ch(Close(f), t)
+
+	unlock := lockByOther(t, f, true, 42)
+	_, err = Open(f, nil)
+	var lockErr *LockError
+	if !errors.Is(err, ErrLocked) || !errors.As(err, &lockErr) || lockErr.PID != 42 {
+		t.Error("opened locked file", err)
+	}
+	if _, err = Get(f, []byte("a")); !errors.Is(err, ErrLocked) {
+		t.Error("read locked file", err)
+	}
+	// wait for lock
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		unlock()
+	}()
+	db, err := Open(f, &Options{Timeout: time.Second})
+	ch(err, t)
+	ch(db.Close(), t)
+
+	// readers share lock
+	unlockRead := lockByOther(t, f, false, 43)
+	if _, err = Open(f, &Options{Timeout: 60 * time.Millisecond}); !errors.Is(err, ErrLocked) {
+		t.Error("opened for write file locked for read", err)
+	}
+	db, err = Open(f, &Options{ReadOnly: true})
+	ch(err, t)
+	if v, err := db.Get([]byte("a")); err != nil || string(v) != "1" {
+		t.Error("read", string(v), err)
+	}
+	ch(db.Close(), t)
+	unlockRead()
+	// lock file is opened read-only
+	if b, _ := ioutil.ReadFile(lockName(f)); string(b) != "43" {
+		t.Error("lock file written by reader", string(b))
+	}
+	if os.Geteuid() != 0 {
+		ch(os.Chmod(lockName(f), 0444), t)
+		db, err = Open(f, &Options{ReadOnly: true})
+		ch(err, t)
+		ch(db.Close(), t)
+	}
+}
+
+func TestDeleteLocked(t *testing.T) {
+	f := "test/TestDeleteLocked.db"
+	DeleteFile(f)
+	defer DeleteFile(f)
+	ch(Set(f, []byte("a"), []byte("1")), t)
+	ch(Close(f), t)
+
+	unlock := lockByOther(t, f, false, 44)
+	if err := DeleteFile(f); !errors.Is(err, ErrLocked) {
+		t.Error("deleted file locked by other", err)
+	}
+	for _, name := range []string{f, f + ".idx", lockName(f)} {
+		if _, err := os.Stat(name); err != nil {
+			t.Error("file of locked file removed", name, err)
+		}
+	}
+	unlock()
+	ch(DeleteFile(f), t)
+	for _, name := range []string{f, f + ".idx", lockName(f)} {
+		if _, err := os.Stat(name); !os.IsNotExist(err) {
+			t.Error("file not removed", name, err)
+		}
+	}
+	// missing file has no lock file
+	DeleteFile(f)
+	if _, err := os.Stat(lockName(f)); !os.IsNotExist(err) {
+		t.Error("lock file of missing file created", err)
+	}
+}
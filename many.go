@@ -0,0 +1,73 @@
+package slowpoke
+
+import (
+	"fmt"
+
+	"github.com/recoilme/pudge"
+)
+
+// KV is key and value pair
+type KV struct {
+	Key   []byte
+	Value []byte
+	Found bool // key exists, set by GetMany
+}
+
+// PairError is returned by SetMany for invalid pair
+type PairError struct {
+	Index  int
+	Reason string
+}
+
+func (e *PairError) Error() string {
+	return fmt.Sprintf("slowpoke: pair %d %s", e.Index, e.Reason)
+}
+
+// GetMany return values of keys in order of keys
+// Missing key has Found false and nil Value
+// Return error on first failed read
+func GetMany(file string, keys [][]byte) ([]KV, error) {
+	f, err := openFile(file)
+	if err != nil {
+		return nil, err
+	}
+	f.RLock()
+	defer f.RUnlock()
+	result := make([]KV, len(keys))
+	for i, key := range keys {
+		result[i].Key = key
+		val, err := f.get(key)
+		switch err {
+		case nil:
+			result[i].Value, result[i].Found = val, true
+		case pudge.ErrKeyNotFound:
+		default:
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+// SetMany store pairs, every pair must contain key and value
+// Pairs are checked before storing, nothing is stored if any pair is invalid
+// Not atomic, use Begin for all-or-nothing writes
+func SetMany(file string, pairs []KV) error {
+	for i, kv := range pairs {
+		if kv.Key == nil {
+			return &PairError{Index: i, Reason: "has nil key"}
+		}
+		if kv.Value == nil {
+			return &PairError{Index: i, Reason: "has nil value"}
+		}
+	}
+	f, err := openFile(file)
+	if err != nil {
+		return err
+	}
+	for _, kv := range pairs {
+		if err = f.put(kv.Key, kv.Value, 0); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,40 @@
+package slowpoke
+
+import (
+	"testing"
+)
+
+func TestGetManySetMany(t *testing.T) {
+	f := "test/TestGetManySetMany.db"
+	DeleteFile(f)
+	defer Close(f)
+	err := SetMany(f, []KV{
+		{Key: []byte("1"), Value: []byte("one")},
+		{Key: []byte("2"), Value: nil},
+	})
+	if perr, ok := err.(*PairError); !ok || perr.Index != 1 {
+		t.Error("want PairError", err)
+	}
+	if cnt, _ := Count(f); cnt != 0 {
+		t.Error("stored with invalid pair", cnt)
+	}
+	ch(SetMany(f, []KV{
+		{Key: []byte("1"), Value: []byte("one")},
+		{Key: []byte("2"), Value: []byte("two")},
+	}), t)
+
+	res, err := GetMany(f, [][]byte{[]byte("2"), []byte("3"), []byte("1")})
+	ch(err, t)
+	if len(res) != 3 {
+		t.Fatal("wrong len", len(res))
+	}
+	if string(res[0].Key) != "2" || string(res[0].Value) != "two" || !res[0].Found {
+		t.Errorf("wrong 2 %+v", res[0])
+	}
+	if string(res[1].Key) != "3" || res[1].Value != nil || res[1].Found {
+		t.Errorf("wrong 3 %+v", res[1])
+	}
+	if string(res[2].Key) != "1" || string(res[2].Value) != "one" || !res[2].Found {
+		t.Errorf("wrong 1 %+v", res[2])
+	}
+}
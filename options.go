@@ -0,0 +1,180 @@
+package slowpoke
+
+import (
+	"os"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/recoilme/pudge"
+)
+
+// Options of opened file
+// Options except Compaction are used on open and kept while file opened,
+// index files of file are opened with the same options
+type Options struct {
+	// Compaction enable automatic compaction of file, nil - disabled
+	Compaction *CompactionPolicy
+	// Sync is when writes are flushed to disk, default SyncNever
+	Sync SyncPolicy
+	// SyncInterval is interval of SyncPeriodic, default 1 second
+	SyncInterval time.Duration
+	// FileMode of created files, default 0666
+	FileMode os.FileMode
+	// DirMode of created dirs, default 0777
+	DirMode os.FileMode
+	// ReadOnly refuse writes with *ReadOnlyError, file must exist
+	// Existing lock file is opened read-only, but files of pudge
+	// are opened for writing, so write permission of files is needed
+	ReadOnly bool
+	// InMemory keep file in memory only, nothing is stored on disk
+	// Data is lost when last DB of file closed
+	InMemory bool
+	// Timeout is time to wait for lock of file held by other process,
+	// 0 - don't wait and return *LockError
+	Timeout time.Duration
+	// Compressor compress stored values, nil - values are not compressed
+	// Values stored before are compressed by Compact
+	Compressor Compressor
+	// CompressMin is min size of compressed value, default 64 bytes
+	CompressMin int
+	// Keys provide keys of values encrypted by AES-GCM, nil - not encrypted
+	// Values stored before are encrypted by Compact, in-memory file
+	// is not encrypted
+	Keys KeyProvider
+	// EncryptKeys encrypt keys in index file too, Keys must be set
+	// Keys of file created before are encrypted by Migrate
+	// Keys of secondary indexes contain indexed values, so they are
+	// always encrypted if Keys set
+	EncryptKeys bool
+}
+
+// SyncPolicy is when writes are flushed to disk
+// Batches and transactions are always flushed on commit
+type SyncPolicy int
+
+const (
+	// SyncNever leave flush to OS (typically every 30 sec or so)
+	SyncNever SyncPolicy = iota
+	// SyncPeriodic flush file every SyncInterval
+	SyncPeriodic
+	// SyncAlways flush file after every write
+	SyncAlways
+)
+
+// ReadOnlyError returned by writes to file opened with ReadOnly
+type ReadOnlyError struct {
+	File string
+}
+
+func (e *ReadOnlyError) Error() string {
+	return "slowpoke: " + e.File + " is opened read-only"
+}
+
+// withDefaults return options with default values, Compaction is not kept
+func (o Options) withDefaults() Options {
+	o.Compaction = nil
+	if o.SyncInterval <= 0 {
+		o.SyncInterval = time.Second
+	}
+	if o.FileMode == 0 {
+		o.FileMode = 0666
+	}
+	if o.DirMode == 0 {
+		o.DirMode = 0777
+	}
+	if o.CompressMin <= 0 {
+		o.CompressMin = compressMin
+	}
+	return o
+}
+
+// equal return true if options are equal, options must be with defaults
+// Compressor and Keys are compared by identity, they may be not comparable
+func (o Options) equal(p Options) bool {
+	if !sameValue(o.Compressor, p.Compressor) || !sameValue(o.Keys, p.Keys) {
+		return false
+	}
+	o.Compressor, o.Keys, p.Compressor, p.Keys = nil, nil, nil, nil
+	return o == p
+}
+
+// sameValue return true if a and b are the same value:
+// pointers (maps, funcs, ...) point to the same data, other values are deeply equal
+func sameValue(a, b interface{}) bool {
+	va, vb := reflect.ValueOf(a), reflect.ValueOf(b)
+	if !va.IsValid() || !vb.IsValid() {
+		return va.IsValid() == vb.IsValid()
+	}
+	if va.Type() != vb.Type() {
+		return false
+	}
+	switch va.Kind() {
+	case reflect.Ptr, reflect.Map, reflect.Slice, reflect.Func, reflect.Chan, reflect.UnsafePointer:
+		return va.Pointer() == vb.Pointer()
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+// config return pudge config of file
+func (f *dbFile) config() *pudge.Config {
+	return &pudge.Config{FileMode: int(f.opts.FileMode), DirMode: int(f.opts.DirMode)}
+}
+
+// openDB open pudge db of file or in-memory db
+func (f *dbFile) openDB(name string) (*pudge.Db, error) {
+	if f.opts.InMemory {
+		return openMemory()
+	}
+	return pudge.Open(name, f.config())
+}
+
+// memoryMu serialize opening of in-memory db
+var memoryMu sync.Mutex
+
+// openMemory return pudge db without file
+// pudge register in-memory db by empty name, so every db is unregistered
+// by Close, which keep data of in-memory db
+func openMemory() (*pudge.Db, error) {
+	memoryMu.Lock()
+	defer memoryMu.Unlock()
+	db, err := pudge.Open("", &pudge.Config{StoreMode: 2})
+	if err != nil {
+		return nil, err
+	}
+	return db, db.Close()
+}
+
+// writable return *ReadOnlyError if file opened read-only
+func (f *dbFile) writable() error {
+	if f.opts.ReadOnly {
+		return &ReadOnlyError{File: f.name}
+	}
+	return nil
+}
+
+// written flush file after write with SyncAlways, return err of write
+func (f *dbFile) written(err error) error {
+	if err != nil || f.opts.Sync != SyncAlways {
+		return err
+	}
+	return f.sync()
+}
+
+// syncer flush file every interval
+func (f *dbFile) syncer(stop chan struct{}, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+		f.RLock()
+		if !f.closed {
+			f.sync()
+		}
+		f.RUnlock()
+	}
+}
@@ -0,0 +1,160 @@
+package slowpoke
+
+import (
+	"errors"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestReadOnly(t *testing.T) {
+	f := "test/TestReadOnly.db"
+	DeleteFile(f)
+	defer DeleteFile(f)
+	ch(Set(f, []byte("a"), []byte("1")), t)
+	ch(Close(f), t)
+
+	db, err := Open(f, &Options{ReadOnly: true})
+	ch(err, t)
+	if v, err := db.Get([]byte("a")); err != nil || string(v) != "1" {
+		t.Error("read", string(v), err)
+	}
+	var roErr *ReadOnlyError
+	if err = db.Set([]byte("b"), []byte("2")); !errors.As(err, &roErr) || roErr.File != f {
+		t.Error("set", err)
+	}
+	if _, err = db.Delete([]byte("a")); !errors.As(err, &roErr) {
+		t.Error("delete", err)
+	}
+	if _, err = db.Counter([]byte("c")); !errors.As(err, &roErr) {
+		t.Error("counter", err)
+	}
+	if err = NewBatch().Set(f, []byte("b"), nil).Commit(); !errors.As(err, &roErr) {
+		t.Error("batch", err)
+	}
+	if err = db.Compact(); !errors.As(err, &roErr) {
+		t.Error("compact", err)
+	}
+	if cnt, _ := db.Count(); cnt != 1 {
+		t.Error("count", cnt)
+	}
+	// other options while opened by DB
+	if _, err = Open(f, &Options{}); err == nil {
+		t.Error("opened with other options")
+	}
+	ch(db.Close(), t)
+
+	// writable again
+	db, err = Open(f, &Options{})
+	ch(err, t)
+	ch(db.Set([]byte("b"), []byte("2")), t)
+	ch(db.Close(), t)
+
+	if _, err = Open("test/TestReadOnlyMissing.db", &Options{ReadOnly: true}); !os.IsNotExist(err) {
+		t.Error("opened missing file", err)
+	}
+}
+
+func TestInMemory(t *testing.T) {
+	f := "test/TestInMemory.db"
+	DeleteFile(f)
+	db, err := Open(f, &Options{InMemory: true})
+	ch(err, t)
+	other, err := Open("test/TestInMemory2.db", &Options{InMemory: true})
+	ch(err, t)
+	ch(db.Set([]byte("a"), []byte("1")), t)
+	ch(db.Set([]byte("b"), []byte("2")), t)
+	ch(other.Set([]byte("c"), []byte("3")), t)
+	ch(db.CreateIndex("tags", tagsIndex), t)
+	ch(db.Set([]byte("p1"), []byte("go")), t)
+	ch(SetWithTTL(f, []byte("d"), []byte("4"), time.Hour), t)
+	ch(CloseAll(), t)
+
+	if _, err = os.Stat(f); !os.IsNotExist(err) {
+		t.Error("file of in-memory db", err)
+	}
+	if cnt, _ := db.Count(); cnt != 4 {
+		t.Error("count", cnt)
+	}
+	if has, _ := db.Has([]byte("c")); has {
+		t.Error("key of other in-memory db")
+	}
+	if kv, err := db.QueryIndex("tags", IndexQuery{Prefix: []byte("go")}); err != nil || len(kv) != 1 {
+		t.Error("index", kv, err)
+	}
+	ch(db.Compact(), t)
+	ch(db.Close(), t)
+	ch(other.Close(), t)
+
+	// data lost on close
+	db, err = Open(f, &Options{InMemory: true})
+	ch(err, t)
+	if cnt, _ := db.Count(); cnt != 0 {
+		t.Error("count after close", cnt)
+	}
+	DropIndex(f, "tags")
+	ch(db.Close(), t)
+	if _, err = os.Stat(indexFileName(f, "tags")); !os.IsNotExist(err) {
+		t.Error("file of in-memory index", err)
+	}
+}
+
+func TestSyncOptions(t *testing.T) {
+	f := "test/TestSyncOptions.db"
+	DeleteFile(f)
+	defer DeleteFile(f)
+	// package functions use file with default options
+	ch(Set(f, []byte("a"), []byte("1")), t)
+
+	db, err := Open(f, &Options{Sync: SyncAlways, FileMode: 0600})
+	ch(err, t)
+	ch(db.Set([]byte("b"), []byte("2")), t)
+	ch(SetWithTTL(f, []byte("c"), []byte("3"), time.Hour), t)
+	fi, err := os.Stat(ttlName(f))
+	ch(err, t)
+	if fi.Mode().Perm() != 0600 {
+		t.Error("file mode", fi.Mode())
+	}
+	ch(db.Close(), t)
+
+	db, err = Open(f, &Options{Sync: SyncPeriodic, SyncInterval: 10 * time.Millisecond})
+	ch(err, t)
+	ch(db.Set([]byte("d"), []byte("4")), t)
+	time.Sleep(30 * time.Millisecond)
+	if cnt, _ := db.Count(); cnt != 4 {
+		t.Error("count", cnt)
+	}
+	ch(db.Close(), t)
+}
+
+func TestFileModes(t *testing.T) {
+	f := "test/TestFileModes.db"
+	dir := "test/TestFileModes"
+	DeleteFile(f)
+	os.RemoveAll(dir)
+	defer DeleteFile(f)
+	defer os.RemoveAll(dir)
+	db, err := Open(f, &Options{FileMode: 0600})
+	ch(err, t)
+	ch(db.Set([]byte("a"), []byte("1")), t)
+	ch(db.Set([]byte("b"), []byte("2")), t)
+	ch(BackupAll(dir), t)
+	fi, err := os.Stat(dir + "/" + f + ".tar")
+	ch(err, t)
+	if fi.Mode().Perm() != 0600 {
+		t.Error("mode of backup", fi.Mode())
+	}
+	ch(db.Close(), t)
+
+	// index rewritten by Repair keeps mode
+	fi, err = os.Stat(f + ".idx")
+	ch(err, t)
+	ch(os.Truncate(f+".idx", fi.Size()-3), t)
+	_, err = Repair(f)
+	ch(err, t)
+	fi, err = os.Stat(f + ".idx")
+	ch(err, t)
+	if fi.Mode().Perm() != 0600 {
+		t.Error("mode of repaired index", fi.Mode())
+	}
+}
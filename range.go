@@ -0,0 +1,51 @@
+package slowpoke
+
+// RangeOptions of Range
+// By default start is included, end is not included and keys are in ascending order
+type RangeOptions struct {
+	StartExclusive bool // start is not included
+	EndInclusive   bool // end is included
+	Limit          int  // max count of keys, 0 - all keys
+	Desc           bool // descending order
+}
+
+// Range return keys between start and end, nil start or end - no bound
+// Keys are compared as bytes, asterix has no special meaning
+func Range(file string, start, end []byte, opts RangeOptions) ([][]byte, error) {
+	if start != nil && opts.StartExclusive {
+		start = append(append([]byte(nil), start...), 0)
+	}
+	if end != nil && opts.EndInclusive {
+		end = append(append([]byte(nil), end...), 0)
+	}
+	it := NewIterator(file, &IteratorOptions{Start: start, End: end})
+	return collectKeys(it, opts.Limit, 0, !opts.Desc)
+}
+
+// KeysByPrefix return keys with prefix in ascending/descending order
+// With limit (0 - all keys) and offset
+func KeysByPrefix(file string, prefix []byte, limit, offset uint32, asc bool) ([][]byte, error) {
+	it := NewIterator(file, &IteratorOptions{Prefix: prefix})
+	return collectKeys(it, int(limit), int(offset), asc)
+}
+
+// collectKeys return keys of iterator
+func collectKeys(it *Iterator, limit, offset int, asc bool) ([][]byte, error) {
+	defer it.Close()
+	next := it.Next
+	if !asc {
+		next = it.Prev
+	}
+	keys := make([][]byte, 0)
+	for next() {
+		if offset > 0 {
+			offset--
+			continue
+		}
+		keys = append(keys, it.Key())
+		if len(keys) == limit {
+			break
+		}
+	}
+	return keys, it.Err()
+}
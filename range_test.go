@@ -0,0 +1,78 @@
+package slowpoke
+
+import (
+	"bytes"
+	"testing"
+)
+
+func joinKeys(keys [][]byte) string {
+	return string(bytes.Join(keys, []byte(" ")))
+}
+
+func TestRange(t *testing.T) {
+	f := "test/TestRange.db"
+	DeleteFile(f)
+	defer Close(f)
+	for _, k := range []string{"a", "b", "b*", "b*1", "c", "d"} {
+		ch(Set(f, []byte(k), nil), t)
+	}
+	cases := []struct {
+		start, end string
+		opts       RangeOptions
+		want       string
+	}{
+		{"b", "c", RangeOptions{}, "b b* b*1"},
+		{"b*", "c", RangeOptions{}, "b* b*1"},
+		{"b*", "c", RangeOptions{StartExclusive: true}, "b*1"},
+		{"b*", "c", RangeOptions{EndInclusive: true}, "b* b*1 c"},
+		{"b", "d", RangeOptions{Limit: 2}, "b b*"},
+		{"b", "d", RangeOptions{Limit: 2, Desc: true}, "c b*1"},
+		{"", "b", RangeOptions{}, "a"},
+		{"c", "", RangeOptions{Desc: true}, "d c"},
+		{"", "", RangeOptions{}, "a b b* b*1 c d"},
+		{"e", "", RangeOptions{}, ""},
+	}
+	for _, c := range cases {
+		var start, end []byte
+		if c.start != "" {
+			start = []byte(c.start)
+		}
+		if c.end != "" {
+			end = []byte(c.end)
+		}
+		keys, err := Range(f, start, end, c.opts)
+		ch(err, t)
+		if s := joinKeys(keys); s != c.want {
+			t.Errorf("Range(%q, %q, %+v) = %q, want %q", c.start, c.end, c.opts, s, c.want)
+		}
+	}
+}
+
+func TestKeysByPrefix(t *testing.T) {
+	f := "test/TestKeysByPrefix.db"
+	DeleteFile(f)
+	defer Close(f)
+	for _, k := range []string{"ka1", "ka2", "ka3", "k*1", "k*2", "kb1"} {
+		ch(Set(f, []byte(k), nil), t)
+	}
+	keys, err := KeysByPrefix(f, []byte("ka"), 0, 0, true)
+	ch(err, t)
+	if s := joinKeys(keys); s != "ka1 ka2 ka3" {
+		t.Error("asc", s)
+	}
+	keys, err = KeysByPrefix(f, []byte("ka"), 1, 1, false)
+	ch(err, t)
+	if s := joinKeys(keys); s != "ka2" {
+		t.Error("desc", s)
+	}
+	keys, err = KeysByPrefix(f, []byte("k*"), 0, 0, true)
+	ch(err, t)
+	if s := joinKeys(keys); s != "k*1 k*2" {
+		t.Error("asterix", s)
+	}
+	keys, err = KeysByPrefix(f, []byte("x"), 0, 0, true)
+	ch(err, t)
+	if len(keys) != 0 {
+		t.Error("not found", joinKeys(keys))
+	}
+}
@@ -0,0 +1,423 @@
+package slowpoke
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+
+	"github.com/recoilme/pudge"
+)
+
+// Secondary index of file is stored in file + "." + name + ".index".
+// Index key is escaped index key (0x00 escaped as 0x00 0xff), 0x00 0x01
+// and primary key, so keys of index are sorted by index key and then
+// by primary key. Writes to file with indexes are applied with writes to
+// index files in one batch.
+
+// ErrNoIndex returned for not created index
+var ErrNoIndex = errors.New("slowpoke: index not found")
+
+// IndexFunc return index keys of value, nil - value not indexed
+// IndexFunc must not modify key and val
+type IndexFunc func(key, val []byte) [][]byte
+
+// secondaryIndex is index of file
+type secondaryIndex struct {
+	name string
+	file string // file of index
+	fn   IndexFunc
+}
+
+// indexes contains created indexes by file
+var indexes = struct {
+	sync.RWMutex
+	m map[string][]secondaryIndex
+}{m: make(map[string][]secondaryIndex)}
+
+// indexFileName return file of index
+func indexFileName(file, name string) string {
+	return file + "." + name + ".index"
+}
+
+// indexesOf return indexes of file
+func indexesOf(file string) []secondaryIndex {
+	indexes.RLock()
+	defer indexes.RUnlock()
+	return indexes.m[file]
+}
+
+// indexOptions return options of index file of file opened with o
+// Keys of index contain indexed values, so they are encrypted
+// if values of file are encrypted
+func indexOptions(o Options) Options {
+	if o.Keys != nil {
+		o.EncryptKeys = true
+	}
+	return o
+}
+
+// indexed return true if file has indexes
+func indexed(file string) bool {
+	return len(indexesOf(file)) > 0
+}
+
+// CreateIndex create index of file, fn return index keys of value
+// Index is built from stored values if index file not exists,
+// so create index before writes on every start of process
+//
+//	slowpoke.CreateIndex(posts, "tags", func(key, val []byte) [][]byte {
+//		var p Post
+//		json.Unmarshal(val, &p)
+//		return p.Tags
+//	})
+func CreateIndex(file, name string, fn IndexFunc) error {
+	if name == "" || fn == nil {
+		return errors.New("slowpoke: index must have name and func")
+	}
+	fs, err := lockFiles([]string{file})
+	if err != nil {
+		return err
+	}
+	f := fs[0]
+	defer f.Unlock()
+	for _, idx := range indexesOf(file) {
+		if idx.name == name {
+			return fmt.Errorf("slowpoke: index %s of %s already exists", name, file)
+		}
+	}
+	idx := secondaryIndex{name: name, file: indexFileName(file, name), fn: fn}
+	// index file is opened with options of file
+	files.Lock()
+	if o, ok := files.opts[file]; ok {
+		files.opts[idx.file] = indexOptions(o)
+	}
+	files.Unlock()
+	_, err = os.Stat(idx.file)
+	switch {
+	case f.opts.InMemory:
+		err = f.buildMemoryIndex(idx)
+	case os.IsNotExist(err):
+		if err = f.writable(); err == nil {
+			err = f.buildIndex(idx)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	indexes.Lock()
+	indexes.m[file] = append(indexes.m[file][:len(indexes.m[file]):len(indexes.m[file])], idx)
+	indexes.Unlock()
+	return nil
+}
+
+// buildIndex write index of all values in new file, file must be locked
+func (f *dbFile) buildIndex(idx secondaryIndex) error {
+	tmp := compactName(idx.file)
+	removeCompaction(idx.file)
+	xf := &dbFile{name: idx.file, opts: indexOptions(f.opts)}
+	ft, err := xf.newFormat()
+	if err == nil {
+		ft, err = createHeader(tmp, f.opts.FileMode, ft)
+	}
+	if err != nil {
+		removeCompaction(idx.file)
+		return err
+	}
+	db, err := pudge.Open(tmp, f.config())
+	if err != nil {
+		removeCompaction(idx.file)
+		return err
+	}
+	mac, err := f.keyMAC(ft)
+	if err == nil {
+		err = f.writeIndex(db, ft, mac, idx)
+	}
+	if err != nil {
+		db.Close()
+		removeCompaction(idx.file)
+		return err
+	}
+	if err = db.Close(); err == nil {
+		err = markCompacted(idx.file, f.opts.FileMode)
+	}
+	if err != nil {
+		removeCompaction(idx.file)
+		return err
+	}
+	return finishCompaction(idx.file)
+}
+
+// buildMemoryIndex write index of all values in opened index file,
+// file must be locked
+func (f *dbFile) buildMemoryIndex(idx secondaryIndex) error {
+	xf, err := openFile(idx.file)
+	if err != nil {
+		return err
+	}
+	xf.Lock()
+	defer xf.Unlock()
+	if err = f.writeIndex(xf.db, xf.format, xf.mac, idx); err != nil {
+		return err
+	}
+	return xf.loadKeys()
+}
+
+// writeIndex write index entries of all values in db of format ft
+// with HMAC key of keys mac, file must be locked
+func (f *dbFile) writeIndex(db *pudge.Db, ft format, mac []byte, idx secondaryIndex) error {
+	for _, key := range f.sortedKeys() {
+		val, err := f.read(key)
+		if err == pudge.ErrKeyNotFound {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		for _, ik := range idx.fn(key, val) {
+			e := indexEntry(ik, key)
+			dk := storedKey(mac, e)
+			b, err := f.encode(ft, dk, e, []byte{})
+			if err == nil {
+				err = db.Set(dk, b)
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// DropIndex remove index of file with its file
+func DropIndex(file, name string) error {
+	fs, err := lockFiles([]string{file})
+	if err != nil {
+		return err
+	}
+	if err = fs[0].writable(); err != nil {
+		fs[0].Unlock()
+		return err
+	}
+	indexes.Lock()
+	var dropped string
+	var rest []secondaryIndex
+	for _, idx := range indexes.m[file] {
+		if idx.name == name {
+			dropped = idx.file
+			continue
+		}
+		rest = append(rest, idx)
+	}
+	if len(rest) == 0 {
+		delete(indexes.m, file)
+	} else {
+		indexes.m[file] = rest
+	}
+	indexes.Unlock()
+	fs[0].Unlock()
+	if dropped == "" {
+		return ErrNoIndex
+	}
+	files.Lock()
+	o := files.opts[dropped]
+	delete(files.opts, dropped)
+	files.Unlock()
+	if o.InMemory {
+		_, err = closeFile(dropped)
+		return err
+	}
+	return DeleteFile(dropped)
+}
+
+// IndexQuery select index keys by prefix and/or range
+// Range bounds, limit and order are set by RangeOptions
+type IndexQuery struct {
+	Prefix []byte // index keys with prefix, nil - all
+	Start  []byte // first index key, nil - no bound
+	End    []byte // last index key, nil - no bound
+	RangeOptions
+}
+
+// QueryIndex return records of file with index keys selected by query,
+// in order of index keys. Record with several selected index keys
+// is returned for every key
+func QueryIndex(file, name string, q IndexQuery) ([]KV, error) {
+	var idxFile string
+	for _, idx := range indexesOf(file) {
+		if idx.name == name {
+			idxFile = idx.file
+		}
+	}
+	if idxFile == "" {
+		return nil, ErrNoIndex
+	}
+	opts := &IteratorOptions{}
+	if q.Prefix != nil {
+		opts.Prefix = escapeIndexKey(nil, q.Prefix)
+	}
+	if q.Start != nil {
+		opts.Start = escapeIndexKey(nil, q.Start)
+		if q.StartExclusive {
+			opts.Start = append(opts.Start, 0x00, 0x02)
+		}
+	}
+	if q.End != nil {
+		opts.End = escapeIndexKey(nil, q.End)
+		if q.EndInclusive {
+			opts.End = append(opts.End, 0x00, 0x02)
+		}
+	}
+	it := NewIterator(idxFile, opts)
+	defer it.Close()
+	next := it.Next
+	if q.Desc {
+		next = it.Prev
+	}
+	f, err := openFile(file)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]KV, 0)
+	for next() {
+		key := primaryKey(it.Key())
+		f.RLock()
+		val, err := f.get(key)
+		f.RUnlock()
+		if err == pudge.ErrKeyNotFound {
+			// record removed after index read
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, KV{Key: key, Value: val, Found: true})
+		if len(result) == q.Limit {
+			break
+		}
+	}
+	return result, it.Err()
+}
+
+// escapeIndexKey append index key with escaped 0x00
+func escapeIndexKey(buf, key []byte) []byte {
+	for _, c := range key {
+		buf = append(buf, c)
+		if c == 0x00 {
+			buf = append(buf, 0xff)
+		}
+	}
+	return buf
+}
+
+// indexEntry return key of index file for index key and primary key
+func indexEntry(ik, key []byte) []byte {
+	buf := escapeIndexKey(make([]byte, 0, len(ik)+len(key)+2), ik)
+	buf = append(buf, 0x00, 0x01)
+	return append(buf, key...)
+}
+
+// primaryKey return primary key of index entry
+func primaryKey(entry []byte) []byte {
+	for i := 0; i+1 < len(entry); i++ {
+		if entry[i] == 0x00 {
+			if entry[i+1] == 0x01 {
+				return entry[i+2:]
+			}
+			i++
+		}
+	}
+	return nil
+}
+
+// lockIndexed lock files with files of their indexes in sorted order
+func lockIndexed(names []string) ([]*dbFile, error) {
+	for {
+		all := withIndexFiles(names)
+		fs, err := lockFiles(all)
+		if err != nil {
+			return nil, err
+		}
+		// index may be created or dropped while we wait
+		if equalStrings(withIndexFiles(names), all) {
+			return fs, nil
+		}
+		for _, f := range fs {
+			f.Unlock()
+		}
+	}
+}
+
+// equalStrings return true if a and b are equal
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// withIndexFiles return sorted names of files and their index files
+func withIndexFiles(names []string) []string {
+	seen := make(map[string]bool)
+	all := make([]string, 0, len(names))
+	for _, name := range names {
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+		all = append(all, name)
+		for _, idx := range indexesOf(name) {
+			if !seen[idx.file] {
+				seen[idx.file] = true
+				all = append(all, idx.file)
+			}
+		}
+	}
+	sort.Strings(all)
+	return all
+}
+
+// addIndexOps add writes of index files for ops, files must be locked
+// Entries of old value are removed and entries of new value are stored
+func addIndexOps(fs []*dbFile, ops map[string]map[string]txOp) error {
+	for _, f := range fs {
+		idxs := indexesOf(f.name)
+		if len(idxs) == 0 {
+			continue
+		}
+		for _, op := range ops[f.name] {
+			old, err := f.read(op.key)
+			exists := err == nil
+			if err == pudge.ErrKeyNotFound {
+				err = nil
+			}
+			if err != nil {
+				return err
+			}
+			for _, idx := range idxs {
+				if ops[idx.file] == nil {
+					ops[idx.file] = make(map[string]txOp)
+				}
+				if exists {
+					for _, ik := range idx.fn(op.key, old) {
+						e := indexEntry(ik, op.key)
+						ops[idx.file][string(e)] = txOp{file: idx.file, key: e, del: true}
+					}
+				}
+				if !op.del {
+					for _, ik := range idx.fn(op.key, op.val) {
+						e := indexEntry(ik, op.key)
+						ops[idx.file][string(e)] = txOp{file: idx.file, key: e, val: []byte{}}
+					}
+				}
+			}
+		}
+	}
+	return nil
+}
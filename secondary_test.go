@@ -0,0 +1,102 @@
+package slowpoke
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+// tagsIndex index comma separated tags of value
+func tagsIndex(key, val []byte) [][]byte {
+	if len(val) == 0 {
+		return nil
+	}
+	return bytes.Split(val, []byte(","))
+}
+
+func queryKeys(t *testing.T, file string, q IndexQuery) string {
+	res, err := QueryIndex(file, "tags", q)
+	ch(err, t)
+	keys := make([][]byte, 0, len(res))
+	for _, kv := range res {
+		keys = append(keys, kv.Key)
+	}
+	return joinKeys(keys)
+}
+
+func TestSecondaryIndex(t *testing.T) {
+	f := "test/TestSecondaryIndex.db"
+	DropIndex(f, "tags")
+	DeleteFile(f)
+	defer Close(f)
+	ch(Set(f, []byte("p1"), []byte("go,db")), t)
+	// index is built from stored values
+	ch(CreateIndex(f, "tags", tagsIndex), t)
+	defer DropIndex(f, "tags")
+	if err := CreateIndex(f, "tags", tagsIndex); err == nil {
+		t.Error("index created twice")
+	}
+	ch(Set(f, []byte("p2"), []byte("go")), t)
+	ch(Set(f, []byte("p3"), []byte("rust,db")), t)
+
+	if s := queryKeys(t, f, IndexQuery{Prefix: []byte("go")}); s != "p1 p2" {
+		t.Error("prefix", s)
+	}
+	if s := queryKeys(t, f, IndexQuery{Start: []byte("db"), End: []byte("go")}); s != "p1 p3" {
+		t.Error("range", s)
+	}
+	if s := queryKeys(t, f, IndexQuery{Start: []byte("db"), End: []byte("go"),
+		RangeOptions: RangeOptions{StartExclusive: true, EndInclusive: true, Desc: true}}); s != "p2 p1" {
+		t.Error("range options", s)
+	}
+
+	// update, delete and transaction change index
+	ch(Set(f, []byte("p1"), []byte("rust")), t)
+	Delete(f, []byte("p2"))
+	tx, _ := Begin(f)
+	tx.Set([]byte("p4"), []byte("go"))
+	tx.Delete([]byte("p3"))
+	ch(tx.Commit(), t)
+	ch(Update(f, []byte("p5"), func(old []byte, exists bool) ([]byte, error) {
+		// fn may read file and its index
+		if _, err := Has(f, []byte("p1")); err != nil {
+			return nil, err
+		}
+		if _, err := Get(f, []byte("p1")); err != nil {
+			return nil, err
+		}
+		if _, err := QueryIndex(f, "tags", IndexQuery{}); err != nil {
+			return nil, err
+		}
+		return []byte("db"), nil
+	}), t)
+	if s := queryKeys(t, f, IndexQuery{}); s != "p5 p4 p1" {
+		t.Error("after update", s)
+	}
+	res, err := QueryIndex(f, "tags", IndexQuery{Prefix: []byte("rust")})
+	ch(err, t)
+	if len(res) != 1 || string(res[0].Value) != "rust" {
+		t.Errorf("value %+v", res)
+	}
+
+	// index survive reopen
+	Close(f)
+	if s := queryKeys(t, f, IndexQuery{Prefix: []byte("go")}); s != "p4" {
+		t.Error("after reopen", s)
+	}
+
+	// expired key removed from index
+	defer clock.stop()()
+	ch(SetWithTTL(f, []byte("p6"), []byte("go"), time.Minute), t)
+	clock.add(time.Hour)
+	fl, _ := openFile(f)
+	fl.sweep()
+	if cnt, _ := Count(indexFileName(f, "tags")); cnt != 3 {
+		t.Error("expired key not removed from index", cnt)
+	}
+
+	ch(DropIndex(f, "tags"), t)
+	if _, err = QueryIndex(f, "tags", IndexQuery{}); err != ErrNoIndex {
+		t.Error("want ErrNoIndex", err)
+	}
+}
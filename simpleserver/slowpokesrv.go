@@ -29,6 +29,16 @@ return: {"username":"xyz","password":"xyz"}
 curl -v localhost:5000/bolt/images/durov2
 return 404 Error
 
+BACKUP:
+
+# params
+host/slowpoke/backup/store
+
+curl -o users.tar localhost:5000/slowpoke/backup/users
+return: tar archive of consistent copy of store, restore it by slowpoke.Restore
+(or 404 Error if store not found)
+key of store named backup is returned instead, if store has it
+
 POST:
 
 # params
@@ -66,6 +76,7 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"time"
@@ -90,10 +101,45 @@ func handlerBolt(w http.ResponseWriter, r *http.Request) {
 	parser(w, r)
 }
 
+// handlerSlowPoke handle store and key paths and backup of store
+// path localhost:5000/slowpoke/backup/store
 func handlerSlowPoke(w http.ResponseWriter, r *http.Request) {
+	urlPart := strings.Split(r.URL.Path, "/")
+	if r.Method == "GET" && len(urlPart) == 4 && urlPart[2] == "backup" && urlPart[3] != "" &&
+		!hasKey("backup", urlPart[3]) {
+		backup(w, urlPart[3])
+		return
+	}
 	parser(w, r)
 }
 
+// hasKey return true if existing store has key,
+// so key of store named backup is not hidden by backup
+func hasKey(store, key string) bool {
+	if _, err := os.Stat(store); err != nil {
+		return false
+	}
+	has, _ := slowpoke.Has(store, []byte(key))
+	return has
+}
+
+// backup write snapshot of slowpoke store
+func backup(w http.ResponseWriter, store string) {
+	// headers are sent with first bytes of snapshot
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+filepath.Base(store)+`.tar"`)
+	err := slowpoke.Snapshot(store, w)
+	if err == nil {
+		return
+	}
+	w.Header().Del("Content-Disposition")
+	if errors.Is(err, os.ErrNotExist) {
+		http.Error(w, "NotFound", http.StatusNotFound)
+		return
+	}
+	http.Error(w, err.Error(), http.StatusInternalServerError)
+}
+
 // Serve run server
 // example addr: ":5000"
 // example usage ./simpleserver :5000>>simpleserver.log &
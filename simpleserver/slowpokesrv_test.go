@@ -1,8 +1,15 @@
 package main
 
 import (
+	"bytes"
 	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
 	"testing"
+
+	"github.com/recoilme/slowpoke"
 )
 
 func TestNil(t *testing.T) {
@@ -16,6 +23,51 @@ func TestNil(t *testing.T) {
 
 }
 
+func TestBackup(t *testing.T) {
+	store, restored := "TestBackup.db", "TestBackupRestored.db"
+	defer slowpoke.DeleteFile(store)
+	defer slowpoke.DeleteFile(restored)
+	defer slowpoke.DeleteFile("backup")
+	defer slowpoke.DeleteFile(restored + "2")
+	do := func(h http.HandlerFunc, method, url, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		h(w, httptest.NewRequest(method, url, strings.NewReader(body)))
+		return w
+	}
+	if w := do(handlerSlowPoke, "PUT", "/slowpoke/"+store+"/k", "v"); w.Code != http.StatusOK {
+		t.Fatal("put", w.Code, w.Body.String())
+	}
+
+	w := do(handlerSlowPoke, "GET", "/slowpoke/backup/"+store, "")
+	if w.Code != http.StatusOK {
+		t.Fatal("backup", w.Code, w.Body.String())
+	}
+	if err := slowpoke.Restore(restored, bytes.NewReader(w.Body.Bytes())); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := slowpoke.Get(restored, []byte("k")); err != nil || string(v) != "v" {
+		t.Error("get of restored store", string(v), err)
+	}
+
+	// missing store is not created
+	if w = do(handlerSlowPoke, "GET", "/slowpoke/backup/TestBackupMissing.db", ""); w.Code != http.StatusNotFound {
+		t.Error("backup of missing store", w.Code)
+	}
+	if _, err := os.Stat("TestBackupMissing.db"); !os.IsNotExist(err) {
+		t.Error("missing store created", err)
+	}
+
+	// store named backup is not hidden
+	do(handlerSlowPoke, "PUT", "/slowpoke/backup/k", "b")
+	if w = do(handlerSlowPoke, "GET", "/slowpoke/backup/k", ""); w.Body.String() != "b" {
+		t.Error("get of store backup", w.Code, w.Body.String())
+	}
+	if w = do(handlerSlowPoke, "GET", "/slowpoke/backup/"+store, ""); w.Code != http.StatusOK ||
+		slowpoke.Restore(restored+"2", bytes.NewReader(w.Body.Bytes())) != nil {
+		t.Error("backup with store backup", w.Code)
+	}
+}
+
 //run server before testing
 /*
 func TestCatPut(t *testing.T) {
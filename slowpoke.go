@@ -6,22 +6,570 @@ import (
 	"bytes"
 	"encoding/binary"
 	"encoding/gob"
+	"errors"
+	"fmt"
+	"os"
 	"reflect"
+	"sync"
 
 	"github.com/recoilme/pudge"
 )
 
+// keyLocks serialize writes to the same file/key
+// Lock of key is created on first use and removed when not used,
+// so writes of different keys never wait each other
+// key lock must be taken before lock of dbFile
+var keyLocks = struct {
+	sync.Mutex
+	m map[string]*keyLock
+}{m: make(map[string]*keyLock)}
+
+// keyLock is a lock of one file/key with count of its users
+type keyLock struct {
+	sync.Mutex
+	refs int // guarded by keyLocks
+}
+
+// lockKey lock file/key and return func for unlock it
+func lockKey(file string, key []byte) (unlock func()) {
+	id := file + "\x00" + string(key)
+	keyLocks.Lock()
+	l := keyLocks.m[id]
+	if l == nil {
+		l = &keyLock{}
+		keyLocks.m[id] = l
+	}
+	l.refs++
+	keyLocks.Unlock()
+	l.Lock()
+	return func() {
+		l.Unlock()
+		keyLocks.Lock()
+		if l.refs--; l.refs == 0 {
+			delete(keyLocks.m, id)
+		}
+		keyLocks.Unlock()
+	}
+}
+
+// dbFile is a file opened by slowpoke
+// Reads and writes of single keys hold read lock,
+// transactions and compaction hold write lock while applied
+type dbFile struct {
+	sync.RWMutex
+	name     string
+	db       *pudge.Db
+	closed   bool
+	opts     Options       // options of file with defaults
+	stopSync chan struct{} // stop periodic sync
+	lock     *os.File      // locked lock file, nil if not locked
+	format   format        // format of value file
+	mac      []byte        // HMAC key of encrypted keys, nil - keys not encrypted
+	plain    *pudge.Db     // in-memory db of decrypted keys, if keys encrypted
+	aeads    sync.Map      // ciphers by key ID
+	seq      uint64        // number of last change sent to watchers, see publish
+
+	compactMu  sync.Mutex          // one compaction at time
+	dirtyMu    sync.Mutex          // guards dirty
+	dirty      map[string]struct{} // keys changed while compaction, if not nil
+	stopPolicy chan struct{}       // stop automatic compaction
+
+	ttlMu      sync.Mutex       // guards fields below
+	ttl        map[string]int64 // expiration time of keys, unix nano
+	ttlDB      *pudge.Db        // file with expiration time
+	nextExpire int64            // min of expiration time, 0 - none
+	stopSweep  chan struct{}    // stop removing of expired keys
+
+	keysMu  sync.RWMutex        // guards fields below
+	sorted  [][]byte            // sorted keys, for iterators
+	added   [][]byte            // new keys not merged in sorted
+	removed map[string]struct{} // removed keys not merged in sorted
+}
+
+// files contains all opened files
+// File opened by DB is not closed by Close, CloseAll and DeleteFile
+var files = struct {
+	sync.RWMutex
+	m        map[string]*dbFile
+	refs     map[string]int           // count of opened DB by file
+	opts     map[string]Options       // options of files opened by DB and their indexes
+	reserved map[string]chan struct{} // closed files changed by Restore or Repair or checked by Verify, see reserveFile
+}{m: make(map[string]*dbFile), refs: make(map[string]int), opts: make(map[string]Options), reserved: make(map[string]chan struct{})}
+
+// reserveFile keep closed file from opening until release called,
+// so its files may be changed without lock of all files
+// Return ErrInUse if file opened
+func reserveFile(name string) (release func(), err error) {
+	files.Lock()
+	defer files.Unlock()
+	for {
+		if _, ok := files.m[name]; ok {
+			return nil, ErrInUse
+		}
+		done, ok := files.reserved[name]
+		if !ok {
+			break
+		}
+		files.Unlock()
+		<-done
+		files.Lock()
+	}
+	done := make(chan struct{})
+	files.reserved[name] = done
+	return func() {
+		files.Lock()
+		delete(files.reserved, name)
+		files.Unlock()
+		close(done)
+	}, nil
+}
+
+// inUse return true if file opened by DB or kept in memory
+func inUse(name string) bool {
+	files.RLock()
+	defer files.RUnlock()
+	return files.refs[name] > 0 || files.opts[name].InMemory
+}
+
+// openFile return opened file or open it
+// On first open not applied journal (if any) will be replayed,
+// file is registered but locked until replay finished
+func openFile(name string) (*dbFile, error) {
+	files.RLock()
+	f, ok := files.m[name]
+	files.RUnlock()
+	if ok {
+		return f, nil
+	}
+	files.Lock()
+	for {
+		if f, ok = files.m[name]; ok {
+			files.Unlock()
+			return f, nil
+		}
+		done, ok := files.reserved[name]
+		if !ok {
+			break
+		}
+		// wait until file restored or repaired
+		files.Unlock()
+		<-done
+		files.Lock()
+	}
+	f = &dbFile{name: name, opts: files.opts[name].withDefaults()}
+	err := f.acquireLock()
+	if err == nil {
+		err = f.prepare()
+	}
+	if err == nil {
+		f.format, err = f.header()
+	}
+	if err == nil {
+		f.mac, err = f.keyMAC(f.format)
+	}
+	if err == nil {
+		f.db, err = f.openDB(name)
+	}
+	if err != nil {
+		f.releaseLock()
+		files.Unlock()
+		return nil, err
+	}
+	f.Lock()
+	defer f.Unlock()
+	files.m[name] = f
+	files.Unlock()
+	if err = f.loadKeys(); err == nil {
+		err = f.checkKey()
+	}
+	if err == nil {
+		err = f.loadTTL()
+	}
+	if err == nil && !f.opts.InMemory && !f.opts.ReadOnly {
+		err = f.replayJournal()
+	}
+	if err != nil {
+		f.drop()
+		return nil, err
+	}
+	if f.opts.Sync == SyncPeriodic && !f.opts.InMemory {
+		f.stopSync = make(chan struct{})
+		go f.syncer(f.stopSync, f.opts.SyncInterval)
+	}
+	return f, nil
+}
+
+// prepare check locked file before open and finish its compaction
+// Read-only file must not need recovery
+func (f *dbFile) prepare() error {
+	switch {
+	case f.opts.InMemory:
+		return nil
+	case !f.opts.ReadOnly:
+		return finishCompaction(f.name)
+	}
+	for _, name := range []string{compactedName(f.name), compactedName(ttlName(f.name)), journalName(f.name)} {
+		if _, err := os.Stat(name); err == nil {
+			return fmt.Errorf("slowpoke: %s needs recovery, open it for writing", f.name)
+		}
+	}
+	return nil
+}
+
+// closeFile remove file from opened files and close it
+// return false if file not opened
+func closeFile(name string) (bool, error) {
+	files.RLock()
+	f, ok := files.m[name]
+	files.RUnlock()
+	if !ok {
+		return false, nil
+	}
+	f.Lock()
+	defer f.Unlock()
+	return true, f.drop()
+}
+
+// drop remove file from opened files and close it, file must be locked
+// Closed file will be opened again on next use
+func (f *dbFile) drop() error {
+	if !f.forget() {
+		return nil
+	}
+	err := f.db.Close()
+	if e := f.closeTTL(); err == nil {
+		err = e
+	}
+	if e := f.releaseLock(); err == nil {
+		err = e
+	}
+	return err
+}
+
+// forget remove file from opened files and mark it closed, file must be locked
+// Return false if file already closed
+func (f *dbFile) forget() bool {
+	files.Lock()
+	defer files.Unlock()
+	if files.m[f.name] != f {
+		return false
+	}
+	delete(files.m, f.name)
+	f.closed = true
+	f.setPolicy(nil)
+	if f.stopSync != nil {
+		close(f.stopSync)
+		f.stopSync = nil
+	}
+	return true
+}
+
+// sync flush value and index files (and expiration time if any) to disk
+func (f *dbFile) sync() error {
+	if f.opts.InMemory {
+		return nil
+	}
+	ttl := ttlName(f.name)
+	for _, name := range []string{f.name, f.name + ".idx", ttl, ttl + ".idx"} {
+		fd, err := os.OpenFile(name, os.O_RDWR, 0)
+		if os.IsNotExist(err) && (name == ttl || name == ttl+".idx") {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		err = fd.Sync()
+		fd.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// get return value by key, file must be locked (read or write)
+// Expired key is not found
+func (f *dbFile) get(key []byte) ([]byte, error) {
+	if f.expired(key) {
+		return nil, pudge.ErrKeyNotFound
+	}
+	return f.read(key)
+}
+
+// read return stored value by key, file must be locked (read or write)
+// Return *CorruptedError if checksum of value not match
+func (f *dbFile) read(key []byte) (val []byte, err error) {
+	dk := f.dbKey(key)
+	if err = f.db.Get(dk, &val); err != nil {
+		return nil, err
+	}
+	_, val, err = f.decodeRecord(dk, val)
+	var ce *CorruptedError
+	if errors.As(err, &ce) {
+		ce.Key = append([]byte(nil), key...)
+	}
+	return val, err
+}
+
+// has return true if key exists and not expired, file must be locked (read or write)
+func (f *dbFile) has(key []byte) (bool, error) {
+	has, err := f.keyDB().Has(key)
+	return has && !f.expired(key), err
+}
+
+// count return count of not expired keys, file must be locked (read or write)
+func (f *dbFile) count() (int, error) {
+	cnt, err := f.db.Count()
+	if err != nil {
+		return cnt, err
+	}
+	for key := range f.expiredKeys() {
+		if has, _ := f.keyDB().Has([]byte(key)); has {
+			cnt--
+		}
+	}
+	return cnt, nil
+}
+
+// keys return not expired keys, file must be locked (read or write)
+// See Keys for params
+func (f *dbFile) keys(from []byte, limit, offset int, asc bool) ([][]byte, error) {
+	expired := f.expiredKeys()
+	var fromKey interface{}
+	if from != nil {
+		fromKey = from
+	}
+	if len(expired) == 0 {
+		return f.keyDB().Keys(fromKey, limit, offset, asc)
+	}
+	all, err := f.keyDB().Keys(fromKey, 0, 0, asc)
+	if err != nil {
+		return all, err
+	}
+	keys := make([][]byte, 0, len(all))
+	for _, key := range all {
+		if _, ok := expired[string(key)]; ok {
+			continue
+		}
+		if offset > 0 {
+			offset--
+			continue
+		}
+		keys = append(keys, key)
+		if len(keys) == limit {
+			break
+		}
+	}
+	return keys, nil
+}
+
+// set store key and val, file must be locked (read or write)
+func (f *dbFile) set(key, val []byte) error {
+	return f.setWithTTL(key, val, 0)
+}
+
+// setWithTTL store key and val with expiration time (unix nano, 0 - never),
+// file must be locked (read or write)
+func (f *dbFile) setWithTTL(key, val []byte, expire int64) (err error) {
+	f.preserve(key)
+	// expiration stored first, so after crash old value may expire or not,
+	// but new value always has right expiration
+	if expire == 0 {
+		err = f.clearTTL(key)
+	} else {
+		err = f.setTTL(key, expire)
+	}
+	if err != nil {
+		return err
+	}
+	dk := f.dbKey(key)
+	rec, err := f.encode(f.format, dk, key, val)
+	if err != nil {
+		return err
+	}
+	f.touch(key)
+	exists, err := f.keyDB().Has(key)
+	if err != nil {
+		return err
+	}
+	if err = f.db.Set(dk, rec); err != nil {
+		return err
+	}
+	if f.plain != nil {
+		f.plain.Set(key, []byte{})
+	}
+	if !exists {
+		f.addKey(key)
+	}
+	f.publish(EventPut, key, val)
+	return nil
+}
+
+// put store key and val with expiration time (0 - never), lock key and file
+// Key of file with indexes is stored with index entries in one batch
+func (f *dbFile) put(key, val []byte, expire int64) error {
+	if err := f.writable(); err != nil {
+		return err
+	}
+	unlock := lockKey(f.name, key)
+	defer unlock()
+	if indexed(f.name) {
+		op := txOp{file: f.name, key: key, val: val, expire: expire}
+		return commit(map[string]map[string]txOp{f.name: {string(key): op}})
+	}
+	f.RLock()
+	defer f.RUnlock()
+	return f.written(f.setWithTTL(key, val, expire))
+}
+
+// remove delete key, lock key and file
+// Return pudge.ErrKeyNotFound if key not exists
+func (f *dbFile) remove(key []byte) error {
+	if err := f.writable(); err != nil {
+		return err
+	}
+	unlock := lockKey(f.name, key)
+	defer unlock()
+	if indexed(f.name) {
+		return commitFunc([]string{f.name}, func() (map[string]map[string]txOp, error) {
+			return deleteOps(f.name, key, false)
+		})
+	}
+	f.RLock()
+	defer f.RUnlock()
+	return f.written(f.delete(key))
+}
+
+// deleteOps return ops for delete of key if it exists (and expired if onlyExpired)
+// File must be locked
+func deleteOps(file string, key []byte, onlyExpired bool) (map[string]map[string]txOp, error) {
+	f, err := openFile(file)
+	if err != nil {
+		return nil, err
+	}
+	has, err := f.keyDB().Has(key)
+	if err == nil && !has {
+		if onlyExpired {
+			// expiration of removed key
+			return nil, f.clearTTL(key)
+		}
+		err = pudge.ErrKeyNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if onlyExpired && !f.expired(key) {
+		return nil, nil
+	}
+	op := txOp{file: file, key: key, del: true}
+	return map[string]map[string]txOp{file: {string(key): op}}, nil
+}
+
+// current return value of key and exists flag, file must be locked (read or write)
+func (f *dbFile) current(key []byte) ([]byte, bool, error) {
+	val, err := f.get(key)
+	if err == pudge.ErrKeyNotFound {
+		return nil, false, nil
+	}
+	return val, err == nil, err
+}
+
+// swap store val (nil - delete) if value of key is still old, lock file
+// Key must be locked
+// Key of file with indexes is stored with index entries in one batch
+// Return false if value changed since it was read
+func (f *dbFile) swap(key, old []byte, exists bool, val []byte) (swapped bool, err error) {
+	if indexed(f.name) {
+		name := f.name
+		err = commitFunc([]string{name}, func() (map[string]map[string]txOp, error) {
+			f, err := openFile(name)
+			if err != nil {
+				return nil, err
+			}
+			cur, ok, err := f.current(key)
+			if err != nil || ok != exists || !bytes.Equal(cur, old) {
+				return nil, err
+			}
+			swapped = true
+			if val == nil && !exists {
+				return nil, nil
+			}
+			op := txOp{file: name, key: key, val: val, del: val == nil}
+			return map[string]map[string]txOp{name: {string(key): op}}, nil
+		})
+		return swapped, err
+	}
+	f.RLock()
+	defer f.RUnlock()
+	cur, ok, err := f.current(key)
+	if err != nil {
+		return false, err
+	}
+	if ok != exists || !bytes.Equal(cur, old) {
+		return false, nil
+	}
+	switch {
+	case val != nil:
+		return true, f.written(f.set(key, val))
+	case exists:
+		return true, f.written(f.delete(key))
+	}
+	return true, nil
+}
+
+// delete remove key, file must be locked (read or write)
+func (f *dbFile) delete(key []byte) error {
+	f.preserve(key)
+	f.touch(key)
+	if err := f.db.Delete(f.dbKey(key)); err != nil {
+		return err
+	}
+	if f.plain != nil {
+		f.plain.Delete(key)
+	}
+	f.removeKey(key)
+	f.publish(EventDelete, key, nil)
+	return f.clearTTL(key)
+}
+
+// touch mark key as changed if file compacted
+func (f *dbFile) touch(key []byte) {
+	f.dirtyMu.Lock()
+	if f.dirty != nil {
+		f.dirty[string(key)] = struct{}{}
+	}
+	f.dirtyMu.Unlock()
+}
+
+// errNotSwapped abort Update in CompareAndSwap
+var errNotSwapped = errors.New("slowpoke: not swapped")
+
+// CounterError returned by Counter/CounterAdd if stored value is not a counter
+type CounterError struct {
+	File string
+	Key  []byte
+	Size int
+}
+
+func (e *CounterError) Error() string {
+	return fmt.Sprintf("slowpoke: value of counter %q in %s has %d bytes, want 8", e.Key, e.File, e.Size)
+}
+
 // Set store val and key with sync at end
 // File - may be existing file or new
 // If path to file contains dirs - dirs will be created
 // If val is nil - will store only key
 func Set(file string, key []byte, val []byte) (err error) {
-	return pudge.Set(file, key, val)
+	f, err := openFile(file)
+	if err != nil {
+		return err
+	}
+	return f.put(key, val, 0)
 }
 
 // Put store val and key with sync at end. It's wrapper for Set.
 func Put(file string, key []byte, val []byte) (err error) {
-	return pudge.Set(file, key, val)
+	return Set(file, key, val)
 }
 
 // SetGob - experimental future for lazy usage, see tests
@@ -33,50 +581,137 @@ func SetGob(file string, key interface{}, val interface{}) (err error) {
 	} else {
 		err = gob.NewEncoder(&bufKey).Encode(key)
 	}
-	return pudge.Set(file, bufKey.Bytes(), val)
+	f, err := openFile(file)
+	if err != nil {
+		return err
+	}
+	v, err := pudge.ValToBinary(val)
+	if err != nil {
+		return err
+	}
+	return f.put(bufKey.Bytes(), v, 0)
 }
 
 // Has return true if key exist or error if any
 func Has(file string, key []byte) (exist bool, err error) {
-	return pudge.Has(file, key)
+	f, err := openFile(file)
+	if err != nil {
+		return false, err
+	}
+	f.RLock()
+	defer f.RUnlock()
+	return f.has(key)
 }
 
 // Count return count of keys or error if any
 func Count(file string) (uint64, error) {
-	cnt, err := pudge.Count(file)
+	f, err := openFile(file)
+	if err != nil {
+		return 0, err
+	}
+	f.RLock()
+	defer f.RUnlock()
+	cnt, err := f.count()
 	return uint64(cnt), err
 }
 
 // Counter return unique uint64
+// It's CounterAdd with delta 1
 func Counter(file string, key []byte) (counter uint64, err error) {
-	val, err := Get(file, key)
-	if val == nil || len(val) != 8 {
-		err = nil
+	c, err := CounterAdd(file, key, 1)
+	return uint64(c), err
+}
+
+// CounterAdd add delta to counter and return new value
+// Counter stored as 8 bytes big endian, missing key is 0
+// Increments of the same key are atomic across goroutines
+// Return *CounterError if stored value is not a counter
+func CounterAdd(file string, key []byte, delta int64) (counter int64, err error) {
+	err = Update(file, key, func(old []byte, exists bool) ([]byte, error) {
 		counter = 0
-	} else {
-		counter = binary.BigEndian.Uint64(val)
+		if exists {
+			if len(old) != 8 {
+				return nil, &CounterError{File: file, Key: key, Size: len(old)}
+			}
+			counter = int64(binary.BigEndian.Uint64(old))
+		}
+		counter += delta
+		b := make([]byte, 8)
+		binary.BigEndian.PutUint64(b, uint64(counter))
+		return b, nil
+	})
+	if err != nil {
+		return 0, err
 	}
-	counter++
-	b := make([]byte, 8)
-	binary.BigEndian.PutUint64(b, counter)
-	err = Set(file, key, b)
-	return counter, err
+	return counter, nil
 }
 
-// Open open/create Db (with dirs)
-// This operation is locked by mutex
-// Return error if any
-// Create .idx file for key storage
-func Open(file string) (db *pudge.Db, err error) {
-	return pudge.Open(file, nil)
+// Update run read-modify-write of key atomically
+// fn get current value and exists flag and return new value
+// If fn return error - nothing stored and error returned
+// If fn return nil value - key deleted (return []byte{} for empty value)
+// fn is called without locks, so it may read and write the store,
+// but if key changed while fn run (by fn too), fn is called again
+// with new value, so fn must not have side effects
+func Update(file string, key []byte, fn func(old []byte, exists bool) ([]byte, error)) error {
+	f, err := openFile(file)
+	if err != nil {
+		return err
+	}
+	if err = f.writable(); err != nil {
+		return err
+	}
+	for {
+		f.RLock()
+		old, exists, err := f.current(key)
+		f.RUnlock()
+		if err != nil {
+			return err
+		}
+		prev := append([]byte(nil), old...)
+		val, err := fn(old, exists)
+		if err != nil {
+			return err
+		}
+		// value is stored only if key not changed since it was read
+		unlock := lockKey(file, key)
+		swapped, err := f.swap(key, prev, exists, val)
+		unlock()
+		if swapped || err != nil {
+			return err
+		}
+	}
+}
+
+// CompareAndSwap store new value if current value equal old
+// Old nil - key must not exist, new nil - delete key
+// Return true if value swapped
+func CompareAndSwap(file string, key, old, new []byte) (swapped bool, err error) {
+	err = Update(file, key, func(cur []byte, exists bool) ([]byte, error) {
+		swapped = false
+		if exists != (old != nil) || !bytes.Equal(cur, old) {
+			return cur, errNotSwapped
+		}
+		swapped = true
+		return new, nil
+	})
+	if err == errNotSwapped {
+		err = nil
+	}
+	return swapped, err
 }
 
 // Get return value by key or nil and error
 // Get will open Db if it closed
 // return error if any
 func Get(file string, key []byte) (val []byte, err error) {
-	err = pudge.Get(file, key, &val)
-	return val, err
+	f, err := openFile(file)
+	if err != nil {
+		return nil, err
+	}
+	f.RLock()
+	defer f.RUnlock()
+	return f.get(key)
 }
 
 // GetGob - experimental future for lazy usage, see tests
@@ -88,7 +723,21 @@ func GetGob(file string, key interface{}, val interface{}) (err error) {
 	} else {
 		err = gob.NewEncoder(&bufKey).Encode(key)
 	}
-	return pudge.Get(file, bufKey.Bytes(), val)
+	f, err := openFile(file)
+	if err != nil {
+		return err
+	}
+	f.RLock()
+	defer f.RUnlock()
+	b, err := f.get(bufKey.Bytes())
+	if err != nil {
+		return err
+	}
+	if v, ok := val.(*[]byte); ok {
+		*v = b
+		return nil
+	}
+	return gob.NewDecoder(bytes.NewReader(b)).Decode(val)
 }
 
 // Keys return keys in ascending  or descending order (false - descending,true - ascending)
@@ -97,42 +746,101 @@ func GetGob(file string, key interface{}, val interface{}) (err error) {
 // If from not nil - return keys after from (from not included)
 // If last byte of from == "*" - return keys with this prefix
 func Keys(file string, from []byte, limit, offset uint32, asc bool) ([][]byte, error) {
-	if from == nil {
-		return pudge.Keys(file, nil, int(limit), int(offset), asc)
+	f, err := openFile(file)
+	if err != nil {
+		return nil, err
 	}
-	return pudge.Keys(file, from, int(limit), int(offset), asc)
+	f.RLock()
+	defer f.RUnlock()
+	return f.keys(from, int(limit), int(offset), asc)
 }
 
 // Close - close Db and free used memory
 // It run finalizer and cancel goroutine
+// File opened by DB is closed by DB.Close
 func Close(file string) (err error) {
+	if inUse(file) {
+		return nil
+	}
+	opened, err := closeFile(file)
+	if opened {
+		return err
+	}
 	return pudge.Close(file)
 }
 
-// CloseAll - close all opened Db
+// CloseAll - close all opened Db, except files opened by DB and in-memory files
 func CloseAll() (err error) {
-	return pudge.CloseAll()
+	files.RLock()
+	names := make([]string, 0, len(files.m))
+	for name := range files.m {
+		if files.refs[name] == 0 && !files.opts[name].InMemory {
+			names = append(names, name)
+		}
+	}
+	files.RUnlock()
+	for _, name := range names {
+		if _, e := closeFile(name); e != nil && err == nil {
+			err = e
+		}
+	}
+	return err
 }
 
 // DeleteFile close file key and file val and delete db from map and disk
 // All data will be loss!
+// Return ErrInUse if file opened by DB and *LockError if file opened by other process
 func DeleteFile(file string) (err error) {
-	return pudge.DeleteFile(file)
+	if inUse(file) {
+		return ErrInUse
+	}
+	if _, err = closeFile(file); err != nil {
+		return err
+	}
+	for _, idx := range indexesOf(file) {
+		if err = DeleteFile(idx.file); err != nil {
+			return err
+		}
+	}
+	// files are removed under lock, so other process can't use them
+	unlock, err := lockDeleted(file)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	removeCompaction(file)
+	removeCompaction(ttlName(file))
+	removeTempDirs(file)
+	if err = removeJournal(file); err != nil {
+		return err
+	}
+	if err = pudge.DeleteFile(ttlName(file)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err = pudge.DeleteFile(file); err != nil {
+		return err
+	}
+	if err = os.Remove(lockName(file)); os.IsNotExist(err) {
+		err = nil
+	}
+	return err
 }
 
 // Gets return key/value pairs in random order
 // result contains key and value
 // Gets not return error if key not found
 // If no keys found return empty result
+// Gets can't report errors: corrupted value is skipped as missing key,
+// use GetsCtx or GetMany to get *CorruptedError
 func Gets(file string, keys [][]byte) (result [][]byte) {
-	db, err := Open(file)
+	f, err := openFile(file)
 	if err != nil {
 		return nil
 	}
-
+	f.RLock()
+	defer f.RUnlock()
 	for _, key := range keys {
-		var v []byte
-		err := db.Get(key, &v)
+		v, err := f.get(key)
 		if err == nil {
 			result = append(result, key)
 			result = append(result, v)
@@ -147,8 +855,7 @@ func Gets(file string, keys [][]byte) (result [][]byte) {
 // every pair must contain key and value
 func Sets(file string, pairs [][]byte) (err error) {
 
-	db, err := Open(file)
-	//fmt.Println("set", db, err)
+	f, err := openFile(file)
 	if err != nil {
 		return err
 	}
@@ -158,8 +865,7 @@ func Sets(file string, pairs [][]byte) (err error) {
 			if pairs[i] == nil || pairs[i-1] == nil {
 				break
 			}
-			err = db.Set(pairs[i-1], pairs[i])
-			if err != nil {
+			if err = f.put(pairs[i-1], pairs[i], 0); err != nil {
 				break
 			}
 		}
@@ -168,11 +874,14 @@ func Sets(file string, pairs [][]byte) (err error) {
 }
 
 // Delete key (always return true)
-// Delete not remove any data from files
+// Delete not remove any data from files, use Compact to reclaim space
 // Return error if any
 func Delete(file string, key []byte) (bool, error) {
-	err := pudge.Delete(file, key)
-	if err == nil {
+	f, err := openFile(file)
+	if err != nil {
+		return false, err
+	}
+	if err = f.remove(key); err == nil {
 		return true, nil
 	}
 	return false, err
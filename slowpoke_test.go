@@ -78,21 +78,39 @@ func TestBase(t *testing.T) {
 }
 
 func TestOpen(t *testing.T) {
-	d, _ := Open("test/open.db")
+	f := "test/open.db"
+	d, err := Open(f, nil)
+	ch(err, t)
 	//fmt.Println(d)
-	Set("test/open.db", []byte("foo"), []byte("bar"))
+	Set(f, []byte("foo"), []byte("bar"))
 	//val, ok := d.ReadKey("foo")
-	ret, _ := Get("test/open.db", []byte("foo"))
+	ret, _ := d.Get([]byte("foo"))
 	if bytes.Compare(ret, []byte("bar")) != 0 {
 		t.Error("not bar", ret)
 	}
-	d.Delete("foo")
-	var v string
-	err := d.Get("foo", &v)
+	d.Delete([]byte("foo"))
+	_, err = Get(f, []byte("foo"))
 	if err != pudge.ErrKeyNotFound {
 		t.Error(err)
 	}
 
+	// file opened by DB is not closed by package functions
+	other, err := Open(f, nil)
+	ch(err, t)
+	ch(other.Set([]byte("foo"), []byte("baz")), t)
+	ch(CloseAll(), t)
+	if DeleteFile(f) != ErrInUse {
+		t.Error("deleted file in use")
+	}
+	ch(other.Close(), t)
+	if v, err := d.Get([]byte("foo")); err != nil || string(v) != "baz" {
+		t.Error("closed by other DB", string(v), err)
+	}
+	ch(d.Close(), t)
+	if _, err = d.Get([]byte("foo")); err != ErrClosed {
+		t.Error("want ErrClosed", err)
+	}
+	ch(DeleteFile(f), t)
 }
 
 func TestAsync(t *testing.T) {
@@ -306,9 +324,8 @@ func TestDelete(t *testing.T) {
 	var err error
 	f := "test/2.db"
 	DeleteFile(f)
-	_, err = Open(f)
+	db, err := Open(f, nil)
 	ch(err, t)
-	defer Close(f)
 	err = Set(f, []byte("1"), []byte("11"))
 	ch(err, t)
 	err = Set(f, []byte("2"), []byte("22"))
@@ -323,14 +340,14 @@ func TestDelete(t *testing.T) {
 	}
 	_, err = Get(f, []byte("2"))
 	logg(err)
-	Close(f)
-	_, err = Open(f)
+	ch(db.Close(), t)
+	db, err = Open(f, nil)
 	ch(err, t)
 	_, err = Get(f, []byte("2"))
 	logg(err)
 	d, _ := Get(f, []byte("1"))
 	logg(d)
-	Close(f)
+	ch(db.Close(), t)
 }
 
 func TestRewriteVal(t *testing.T) {
@@ -338,9 +355,9 @@ func TestRewriteVal(t *testing.T) {
 	f := "test/TestRewriteVal.db"
 	//fmt.Println("123")
 	DeleteFile(f)
-	_, err = Open(f)
+	db, err := Open(f, nil)
 	ch(err, t)
-	defer CloseAll()
+	defer db.Close()
 
 	ch(Set(f, []byte("key1"), []byte("val1")), t)
 	ch(Set(f, []byte("key1"), []byte("val2")), t)
@@ -358,9 +375,9 @@ func TestKeys(t *testing.T) {
 	var err error
 	f := "test/keys.db"
 	DeleteFile(f)
-	_, err = Open(f)
+	db, err := Open(f, nil)
 	ch(err, t)
-	defer Close(f)
+	defer db.Close()
 	append := func(i int) {
 
 		k := []byte(fmt.Sprintf("%02d", i))
@@ -527,7 +544,8 @@ func TestWriteRead(t *testing.T) {
 		//mutex.Unlock()
 	}
 	_ = read
-	Open(file)
+	db, _ := Open(file, nil)
+	defer db.Close()
 	for i := 1; i <= len; i++ {
 		wg.Add(2)
 		go append(i)
@@ -840,3 +858,237 @@ func TestAsterix(t *testing.T) {
 	*/
 	Close(f)
 }
+
+func TestCounterAsync(t *testing.T) {
+	f := "test/TestCounterAsync.db"
+	DeleteFile(f)
+	defer Close(f)
+	key := []byte("counter")
+	workers, incs := 16, 200
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < incs; i++ {
+				var err error
+				if w%2 == 0 {
+					_, err = Counter(f, key)
+				} else {
+					_, err = CounterAdd(f, key, 1)
+				}
+				ch(err, t)
+			}
+		}(w)
+	}
+	wg.Wait()
+	counter, err := CounterAdd(f, key, 0)
+	ch(err, t)
+	if counter != int64(workers*incs) {
+		t.Error("lost increments", counter, workers*incs)
+	}
+	counter, err = CounterAdd(f, key, -int64(workers*incs)-1)
+	ch(err, t)
+	if counter != -1 {
+		t.Error("counter!=-1", counter)
+	}
+}
+
+func TestCounterCorrupted(t *testing.T) {
+	f := "test/TestCounterCorrupted.db"
+	DeleteFile(f)
+	defer Close(f)
+	key := []byte("counter")
+	ch(Set(f, key, []byte("abc")), t)
+	_, err := Counter(f, key)
+	cerr, ok := err.(*CounterError)
+	if !ok || cerr.Size != 3 || !bytes.Equal(cerr.Key, key) {
+		t.Error("want CounterError", err)
+	}
+	val, _ := Get(f, key)
+	if !bytes.Equal(val, []byte("abc")) {
+		t.Error("corrupted counter overwritten", val)
+	}
+}
+
+func TestCompareAndSwap(t *testing.T) {
+	f := "test/TestCompareAndSwap.db"
+	DeleteFile(f)
+	defer Close(f)
+	key := []byte("key")
+	swapped, err := CompareAndSwap(f, key, nil, []byte("1"))
+	ch(err, t)
+	if !swapped {
+		t.Error("not swapped on missing key")
+	}
+	swapped, err = CompareAndSwap(f, key, nil, []byte("2"))
+	ch(err, t)
+	if swapped {
+		t.Error("swapped existing key")
+	}
+	swapped, err = CompareAndSwap(f, key, []byte("2"), []byte("3"))
+	ch(err, t)
+	if swapped {
+		t.Error("swapped not equal value")
+	}
+	swapped, err = CompareAndSwap(f, key, []byte("1"), []byte("3"))
+	ch(err, t)
+	if v, _ := Get(f, key); !swapped || !bytes.Equal(v, []byte("3")) {
+		t.Error("not swapped", string(v))
+	}
+	swapped, err = CompareAndSwap(f, key, []byte("3"), nil)
+	ch(err, t)
+	if has, _ := Has(f, key); !swapped || has {
+		t.Error("not deleted")
+	}
+}
+
+func TestUpdate(t *testing.T) {
+	f := "test/TestUpdate.db"
+	DeleteFile(f)
+	defer Close(f)
+	key := []byte("list")
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			err := Update(f, key, func(old []byte, exists bool) ([]byte, error) {
+				return append(old, byte(i)), nil
+			})
+			ch(err, t)
+		}(i)
+	}
+	wg.Wait()
+	v, err := Get(f, key)
+	ch(err, t)
+	if len(v) != 50 {
+		t.Error("lost updates", len(v))
+	}
+
+	stop := fmt.Errorf("stop")
+	err = Update(f, key, func(old []byte, exists bool) ([]byte, error) {
+		return nil, stop
+	})
+	if err != stop {
+		t.Error("want stop", err)
+	}
+	err = Update(f, key, func(old []byte, exists bool) ([]byte, error) {
+		if !exists {
+			t.Error("not exists")
+		}
+		return nil, nil
+	})
+	ch(err, t)
+	if has, _ := Has(f, key); has {
+		t.Error("not deleted")
+	}
+}
+
+func TestUpdateReentrant(t *testing.T) {
+	f := "test/TestUpdateReentrant.db"
+	f2 := "test/TestUpdateReentrant2.db"
+	DeleteFile(f)
+	DeleteFile(f2)
+	defer Close(f)
+	defer Close(f2)
+	// keys "a" and "k68" shared one lock before locks by key
+	err := Update(f, []byte("a"), func(old []byte, exists bool) ([]byte, error) {
+		if err := Set(f, []byte("k68"), []byte("1")); err != nil {
+			return nil, err
+		}
+		if _, err := Delete(f, []byte("k68")); err != nil {
+			return nil, err
+		}
+		if err := Update(f2, []byte("a"), func(old []byte, exists bool) ([]byte, error) {
+			return []byte("2"), nil
+		}); err != nil {
+			return nil, err
+		}
+		return []byte("1"), nil
+	})
+	ch(err, t)
+	if v, _ := Get(f2, []byte("a")); !bytes.Equal(v, []byte("2")) {
+		t.Error("nested update not stored", string(v))
+	}
+	if v, _ := Get(f, []byte("a")); !bytes.Equal(v, []byte("1")) {
+		t.Error("update not stored", string(v))
+	}
+
+	// update of a write b while update of b write a
+	var wg sync.WaitGroup
+	done := make(chan struct{})
+	for i := 0; i < 20; i++ {
+		for _, pair := range [][2]string{{"a", "b"}, {"b", "a"}} {
+			wg.Add(1)
+			go func(k, other string) {
+				defer wg.Done()
+				ch(Update(f, []byte(k), func(old []byte, exists bool) ([]byte, error) {
+					return []byte("1"), Set(f, []byte(other), []byte("2"))
+				}), t)
+			}(pair[0], pair[1])
+		}
+	}
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("deadlock of updates writing each other keys")
+	}
+
+	keyLocks.Lock()
+	for _, id := range []string{f + "\x00a", f + "\x00b", f + "\x00k68", f2 + "\x00a"} {
+		if _, ok := keyLocks.m[id]; ok {
+			t.Error("key lock not removed", id)
+		}
+	}
+	keyLocks.Unlock()
+}
+
+func TestUpdateWithWriter(t *testing.T) {
+	f := "test/TestUpdateWithWriter.db"
+	DeleteFile(f)
+	defer Close(f)
+	key := []byte("k")
+	ch(Set(f, key, []byte("1")), t)
+	calls := 0
+	done := make(chan error, 1)
+	go func() {
+		done <- Update(f, key, func(old []byte, exists bool) ([]byte, error) {
+			calls++
+			if calls == 1 {
+				// transaction wait for lock of file while fn run
+				committed := make(chan error)
+				go func() {
+					tx, err := Begin(f)
+					if err == nil {
+						tx.Set(key, []byte("2"))
+						err = tx.Commit()
+					}
+					committed <- err
+				}()
+				time.Sleep(50 * time.Millisecond)
+				if _, err := Get(f, key); err != nil {
+					return nil, err
+				}
+				if err := <-committed; err != nil {
+					return nil, err
+				}
+			}
+			return append(old, 'x'), nil
+		})
+	}()
+	select {
+	case err := <-done:
+		ch(err, t)
+	case <-time.After(5 * time.Second):
+		t.Fatal("deadlock of Get in Update")
+	}
+	// value changed by transaction is passed to fn again
+	if v, _ := Get(f, key); !bytes.Equal(v, []byte("2x")) || calls != 2 {
+		t.Error("update lost transaction", string(v), calls)
+	}
+}
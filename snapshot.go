@@ -0,0 +1,345 @@
+package slowpoke
+
+import (
+	"archive/tar"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/recoilme/pudge"
+)
+
+// Snapshot is tar archive of files of consistent copy of file:
+// value file (with base name of file) and its index file (name + ".idx"),
+// file with expiration time of keys and its index file (name + ".ttl",
+// name + ".ttl.idx") if keys have expiration time.
+// Values are stored as in file (compressed and encrypted).
+// Indexes of file are not stored, they are built by CreateIndex after Restore.
+
+// snapshotExts is extensions of files in snapshot
+var snapshotExts = []string{"", ".idx", ".ttl", ".ttl.idx"}
+
+// tempSuffixes is suffixes of temp dirs of file: copy of file while
+// snapshot and files of snapshot while restore
+var tempSuffixes = []string{".snapshot", ".restore"}
+
+// tempDir create temp dir near file with name of file, suffix
+// and random part, so concurrent snapshots don't share files
+func tempDir(file, suffix string, mode os.FileMode) (string, error) {
+	if err := os.MkdirAll(filepath.Dir(file), mode); err != nil {
+		return "", err
+	}
+	return ioutil.TempDir(filepath.Dir(file), filepath.Base(file)+suffix)
+}
+
+// removeTempDirs remove temp dirs of file left by interrupted
+// snapshots and restores
+func removeTempDirs(file string) {
+	dir := filepath.Dir(file)
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return
+	}
+	for _, e := range entries {
+		for _, suffix := range tempSuffixes {
+			if e.IsDir() && strings.HasPrefix(e.Name(), filepath.Base(file)+suffix) {
+				os.RemoveAll(filepath.Join(dir, e.Name()))
+			}
+		}
+	}
+}
+
+// Snapshot write consistent copy of file to w, see Snapshot format
+// Readers and writers keep working while values copied,
+// and wait only while changed keys copied, copy is consistent at that moment
+// Copy is written in temp dir near file (file + ".snapshot" + random part)
+// before it's written to w
+// Return error matched by errors.Is(err, os.ErrNotExist) if file not exists
+func Snapshot(file string, w io.Writer) error {
+	files.RLock()
+	_, opened := files.m[file]
+	files.RUnlock()
+	if !opened {
+		// file is not created by snapshot
+		if _, err := os.Stat(file); err != nil {
+			return err
+		}
+	}
+	f, err := openFile(file)
+	if err != nil {
+		return err
+	}
+	dir, err := tempDir(file, ".snapshot", f.opts.DirMode)
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	base := filepath.Base(file)
+	tmp := filepath.Join(dir, base)
+	if err = f.snapshot(tmp); err != nil {
+		return err
+	}
+	tw := tar.NewWriter(w)
+	for _, ext := range snapshotExts {
+		if err = addFile(tw, tmp+ext, base+ext); err != nil {
+			if os.IsNotExist(err) && ext != "" && ext != ".idx" {
+				continue
+			}
+			return err
+		}
+	}
+	return tw.Close()
+}
+
+// snapshot write consistent copy of file in new file name and name + ".ttl"
+func (f *dbFile) snapshot(name string) error {
+	f.compactMu.Lock()
+	defer f.compactMu.Unlock()
+	ft, err := f.newFormat()
+	if err == nil {
+		ft, err = createHeader(name, f.opts.FileMode, ft)
+	}
+	var mac []byte
+	if err == nil {
+		mac, err = f.keyMAC(ft)
+	}
+	if err != nil {
+		return err
+	}
+	db, err := pudge.Open(name, f.config())
+	if err != nil {
+		return err
+	}
+	err = f.copyLive(db, ft, mac, func() error {
+		if err := db.Close(); err != nil {
+			return err
+		}
+		return f.copyTTL(ttlName(name), mac)
+	})
+	if err != nil {
+		db.Close()
+	}
+	return err
+}
+
+// copyTTL write expiration time of keys in file name, file must be locked
+func (f *dbFile) copyTTL(name string, mac []byte) error {
+	f.ttlMu.Lock()
+	defer f.ttlMu.Unlock()
+	if len(f.ttl) == 0 {
+		return nil
+	}
+	db, err := pudge.Open(name, f.config())
+	if err != nil {
+		return err
+	}
+	b := make([]byte, 8)
+	for key, expire := range f.ttl {
+		binary.BigEndian.PutUint64(b, uint64(expire))
+		if err = db.Set(storedKey(mac, []byte(key)), b); err != nil {
+			break
+		}
+	}
+	if e := db.Close(); err == nil {
+		err = e
+	}
+	return err
+}
+
+// addFile write file in archive with name
+func addFile(tw *tar.Writer, file, name string) error {
+	fd, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer fd.Close()
+	fi, err := fd.Stat()
+	if err != nil {
+		return err
+	}
+	hdr, err := tar.FileInfoHeader(fi, "")
+	if err != nil {
+		return err
+	}
+	hdr.Name = name
+	if err = tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	_, err = io.CopyN(tw, fd, fi.Size())
+	return err
+}
+
+// BackupAll write snapshots of all opened files in dir,
+// snapshot of file is written in dir/file + ".tar"
+// Return first error, snapshots of other files are written
+func BackupAll(dir string) (err error) {
+	files.RLock()
+	opened := make(map[string]Options, len(files.m))
+	for name, f := range files.m {
+		opened[name] = f.opts
+	}
+	files.RUnlock()
+	for name, opts := range opened {
+		if e := backup(name, filepath.Join(dir, name)+".tar", opts); e != nil && err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
+// backup write snapshot of file in new file name
+// with modes of created files and dirs of opts
+func backup(file, name string, opts Options) error {
+	if err := os.MkdirAll(filepath.Dir(name), opts.DirMode); err != nil {
+		return err
+	}
+	fd, err := os.OpenFile(name+".tmp", os.O_CREATE|os.O_TRUNC|os.O_WRONLY, opts.FileMode)
+	if err != nil {
+		return err
+	}
+	err = Snapshot(file, fd)
+	if err == nil {
+		err = fd.Sync()
+	}
+	if e := fd.Close(); err == nil {
+		err = e
+	}
+	if err == nil {
+		err = os.Rename(name+".tmp", name)
+	}
+	if err != nil {
+		os.Remove(name + ".tmp")
+	}
+	return err
+}
+
+// Restore replace file with snapshot written by Snapshot
+// Files of snapshot are written in temp dir near file (file + ".restore"
+// + random part), then moved as compacted files and replace file
+// as on compaction, so file is restored or not after crash
+// File is not opened while replaced, other files are used as usual
+// Indexes of file are dropped, create them again by CreateIndex
+// Return ErrInUse if file opened by DB
+func Restore(file string, r io.Reader) error {
+	if inUse(file) {
+		return ErrInUse
+	}
+	files.RLock()
+	opts := files.opts[file].withDefaults()
+	files.RUnlock()
+	dir, err := tempDir(file, ".restore", opts.DirMode)
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	staged := filepath.Join(dir, filepath.Base(file))
+	if err = restoreFiles(staged, r, opts.FileMode); err != nil {
+		return err
+	}
+
+	for _, idx := range indexesOf(file) {
+		if err = DropIndex(file, idx.name); err != nil {
+			return err
+		}
+	}
+	if _, err = closeFile(file); err != nil {
+		return err
+	}
+	release, err := reserveFile(file)
+	if err != nil {
+		// opened again while closed
+		return err
+	}
+	defer release()
+	lf := &dbFile{name: file, opts: opts}
+	if err = lf.acquireLock(); err != nil {
+		return err
+	}
+	defer lf.releaseLock()
+	removeCompaction(file)
+	removeCompaction(ttlName(file))
+	for _, name := range []string{compactName(file), compactName(ttlName(file))} {
+		for _, ext := range []string{"", ".idx"} {
+			err = os.Rename(filepath.Join(dir, filepath.Base(name))+ext, name+ext)
+			if err != nil && !os.IsNotExist(err) {
+				removeCompaction(file)
+				removeCompaction(ttlName(file))
+				return err
+			}
+		}
+	}
+	if err = removeJournal(file); err != nil {
+		return err
+	}
+	// restore is committed by marker of file, expiration time of file
+	// is replaced by finishCompaction of file, so it's never replaced alone
+	if err = markRestored(file, opts.FileMode); err != nil {
+		return err
+	}
+	if err = finishCompaction(file); err != nil {
+		return err
+	}
+	return finishCompaction(ttlName(file))
+}
+
+// restoreFiles write files of snapshot as compacted files of file with sync
+func restoreFiles(file string, r io.Reader, mode os.FileMode) error {
+	tr := tar.NewReader(r)
+	base := ""
+	found := make(map[string]bool)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if base == "" {
+			// value file is first
+			base = hdr.Name
+		}
+		ext := strings.TrimPrefix(hdr.Name, base)
+		var name string
+		switch ext {
+		case "", ".idx":
+			name = compactName(file) + ext
+		case ".ttl", ".ttl.idx":
+			name = compactName(ttlName(file)) + strings.TrimPrefix(ext, ".ttl")
+		default:
+			return fmt.Errorf("slowpoke: unexpected file %s in snapshot", hdr.Name)
+		}
+		if err = writeFile(name, tr, mode); err != nil {
+			return err
+		}
+		found[ext] = true
+	}
+	if !found[""] || !found[".idx"] || found[".ttl"] != found[".ttl.idx"] {
+		return errors.New("slowpoke: snapshot is not complete")
+	}
+	if _, err := readHeader(compactName(file)); err != nil {
+		return err
+	}
+	return nil
+}
+
+// writeFile write r in file with sync
+func writeFile(name string, r io.Reader, mode os.FileMode) error {
+	fd, err := os.OpenFile(name, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, mode)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(fd, r)
+	if err == nil {
+		err = fd.Sync()
+	}
+	if e := fd.Close(); err == nil {
+		err = e
+	}
+	return err
+}
@@ -0,0 +1,256 @@
+package slowpoke
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSnapshot(t *testing.T) {
+	f := "test/TestSnapshot.db"
+	g := "test/TestSnapshot2.db"
+	DeleteFile(f)
+	DeleteFile(g)
+	defer DeleteFile(f)
+	defer DeleteFile(g)
+	for i := 0; i < 100; i++ {
+		ch(Set(f, []byte(fmt.Sprintf("%03d", i)), []byte(fmt.Sprintf("val%d", i))), t)
+	}
+	ch(SetWithTTL(f, []byte("ttl"), []byte("1"), time.Hour), t)
+
+	// writes continue while snapshot
+	var wg sync.WaitGroup
+	done := make(chan struct{})
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; ; i++ {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			ch(Set(f, []byte(fmt.Sprintf("%03d", i%100)), []byte(fmt.Sprintf("new%d", i))), t)
+		}
+	}()
+	var buf bytes.Buffer
+	err := Snapshot(f, &buf)
+	close(done)
+	wg.Wait()
+	ch(err, t)
+	if names, _ := filepath.Glob(f + ".snapshot*"); len(names) != 0 {
+		t.Error("copy of snapshot not removed", names)
+	}
+
+	ch(Restore(g, bytes.NewReader(buf.Bytes())), t)
+	if cnt, err := Count(g); err != nil || cnt != 101 {
+		t.Error("count of restored file", cnt, err)
+	}
+	missing := "test/TestSnapshotMissing.db"
+	if err = Snapshot(missing, &buf); !errors.Is(err, os.ErrNotExist) {
+		t.Error("snapshot of missing file", err)
+	}
+	if _, err = os.Stat(missing); !os.IsNotExist(err) {
+		t.Error("snapshot created file", err)
+	}
+	if v, err := Get(g, []byte("050")); err != nil || (string(v) != "val50" && !bytes.HasPrefix(v, []byte("new"))) {
+		t.Error("get of restored file", string(v), err)
+	}
+	if ttl, err := TTL(g, []byte("ttl")); err != nil || ttl <= 0 {
+		t.Error("ttl of restored file", ttl, err)
+	}
+
+	// restore replace values
+	ch(Set(g, []byte("extra"), []byte("1")), t)
+	ch(Restore(g, bytes.NewReader(buf.Bytes())), t)
+	if has, err := Has(g, []byte("extra")); err != nil || has {
+		t.Error("restore not replaced file", has, err)
+	}
+
+	db, err := Open(g, nil)
+	ch(err, t)
+	if err = Restore(g, bytes.NewReader(buf.Bytes())); !errors.Is(err, ErrInUse) {
+		t.Error("restore of opened file", err)
+	}
+	ch(db.Close(), t)
+	if err = Restore(g, bytes.NewReader(buf.Bytes()[:100])); err == nil {
+		t.Error("restore of broken snapshot")
+	}
+	if cnt, err := Count(g); err != nil || cnt != 101 {
+		t.Error("count after broken restore", cnt, err)
+	}
+}
+
+func TestSnapshotConcurrent(t *testing.T) {
+	f := "test/TestSnapshotConcurrent.db"
+	DeleteFile(f)
+	defer DeleteFile(f)
+	for i := 0; i < 2000; i++ {
+		ch(Set(f, []byte(fmt.Sprintf("%04d", i)), []byte("1")), t)
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			g := fmt.Sprintf("test/TestSnapshotConcurrent%d.db", i)
+			DeleteFile(g)
+			defer DeleteFile(g)
+			var buf bytes.Buffer
+			ch(Snapshot(f, &buf), t)
+			ch(Restore(g, &buf), t)
+			if cnt, err := Count(g); err != nil || cnt != 2000 {
+				t.Error("count of restored file", cnt, err)
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
+func TestRestoreSlowReader(t *testing.T) {
+	f := "test/TestRestoreSlowReader.db"
+	g := "test/TestRestoreSlowReader2.db"
+	other := "test/TestRestoreSlowReader3.db"
+	for _, name := range []string{f, g, other} {
+		DeleteFile(name)
+		defer DeleteFile(name)
+	}
+	ch(Set(f, []byte("a"), []byte("1")), t)
+	var buf bytes.Buffer
+	ch(Snapshot(f, &buf), t)
+
+	// other files are opened while snapshot is read
+	pr, pw := io.Pipe()
+	done := make(chan error, 1)
+	go func() {
+		done <- Restore(g, pr)
+	}()
+	opened := make(chan error, 1)
+	go func() {
+		opened <- Set(other, []byte("a"), []byte("1"))
+	}()
+	select {
+	case err := <-opened:
+		ch(err, t)
+	case <-time.After(5 * time.Second):
+		t.Fatal("open of other file wait for restore")
+	}
+	_, err := pw.Write(buf.Bytes())
+	ch(err, t)
+	ch(pw.Close(), t)
+	ch(<-done, t)
+	if v, err := Get(g, []byte("a")); err != nil || string(v) != "1" {
+		t.Error("get of restored file", string(v), err)
+	}
+	if names, _ := filepath.Glob(g + ".restore*"); len(names) != 0 {
+		t.Error("files of snapshot not removed", names)
+	}
+}
+
+func TestBackupAll(t *testing.T) {
+	f := "test/TestBackupAll.db"
+	g := "test/TestBackupAll2.db"
+	dir := "test/backup"
+	DeleteFile(f)
+	DeleteFile(g)
+	os.RemoveAll(dir)
+	defer DeleteFile(f)
+	defer DeleteFile(g)
+	defer os.RemoveAll(dir)
+	ch(Set(f, []byte("a"), []byte("1")), t)
+	ch(BackupAll(dir), t)
+	fd, err := os.Open(dir + "/" + f + ".tar")
+	ch(err, t)
+	defer fd.Close()
+	ch(Restore(g, fd), t)
+	if v, err := Get(g, []byte("a")); err != nil || string(v) != "1" {
+		t.Error("get of backup", string(v), err)
+	}
+}
+
+func TestSnapshotEncrypted(t *testing.T) {
+	f := "test/TestSnapshotEncrypted.db"
+	g := "test/TestSnapshotEncrypted2.db"
+	DeleteFile(f)
+	DeleteFile(g)
+	defer DeleteFile(f)
+	defer DeleteFile(g)
+	opts := &Options{Keys: NewKeyring(1, bytes.Repeat([]byte{1}, 32)), EncryptKeys: true}
+	db, err := Open(f, opts)
+	ch(err, t)
+	ch(db.CreateIndex("tags", tagsIndex), t)
+	ch(db.Set([]byte("user1"), []byte("go1")), t)
+	var buf bytes.Buffer
+	ch(Snapshot(f, &buf), t)
+	ch(db.Close(), t)
+	if bytes.Contains(buf.Bytes(), []byte("user1")) || bytes.Contains(buf.Bytes(), []byte("go1")) {
+		t.Error("snapshot not encrypted")
+	}
+
+	ch(Restore(g, &buf), t)
+	db, err = Open(g, opts)
+	ch(err, t)
+	if v, err := db.Get([]byte("user1")); err != nil || string(v) != "go1" {
+		t.Error("get of restored file", string(v), err)
+	}
+	ch(db.Close(), t)
+}
+
+func TestRestoreCrash(t *testing.T) {
+	f := "test/TestRestoreCrash.db"
+	g := "test/TestRestoreCrash2.db"
+	DeleteFile(f)
+	DeleteFile(g)
+	defer DeleteFile(f)
+	defer DeleteFile(g)
+	// snapshot without expiration time and with it
+	ch(Set(g, []byte("new"), []byte("1")), t)
+	var plain, expiring bytes.Buffer
+	ch(Snapshot(g, &plain), t)
+	ch(SetWithTTL(g, []byte("new"), []byte("1"), time.Hour), t)
+	ch(Snapshot(g, &expiring), t)
+
+	reset := func() {
+		DeleteFile(f)
+		ch(SetWithTTL(f, []byte("old"), []byte("1"), time.Hour), t)
+		ch(Close(f), t)
+	}
+	for _, snap := range []*bytes.Buffer{&plain, &expiring} {
+		// crash before restore committed
+		reset()
+		ch(restoreFiles(f, bytes.NewReader(snap.Bytes()), 0666), t)
+		if ttl, err := TTL(f, []byte("old")); err != nil || ttl <= 0 {
+			t.Error("old file not kept", ttl, err)
+		}
+		for _, name := range []string{compactName(f), compactName(ttlName(f))} {
+			if _, err := os.Stat(name); !os.IsNotExist(err) {
+				t.Error("new file not removed", name, err)
+			}
+		}
+
+		// crash after restore committed
+		reset()
+		ch(restoreFiles(f, bytes.NewReader(snap.Bytes()), 0666), t)
+		ch(markRestored(f, 0666), t)
+		if has, _ := Has(f, []byte("old")); has {
+			t.Error("restore not finished")
+		}
+		ttl, err := TTL(f, []byte("new"))
+		switch {
+		case err != nil:
+			t.Error("restored key", err)
+		case (snap == &expiring) != (ttl > 0):
+			t.Error("expiration time of snapshot not restored", ttl)
+		}
+		if _, err = os.Stat(compactedName(ttlName(f))); !os.IsNotExist(err) {
+			t.Error("marker of expiration time not removed", err)
+		}
+		ch(Close(f), t)
+	}
+}
@@ -0,0 +1,250 @@
+package slowpoke
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+)
+
+// Codec encode values of type T to bytes and back
+// Codec of keys must keep order: encoded keys are sorted as bytes
+type Codec[T any] interface {
+	Encode(v T) ([]byte, error)
+	Decode(b []byte) (T, error)
+}
+
+// Signed is signed integer types
+type Signed interface {
+	~int | ~int8 | ~int16 | ~int32 | ~int64
+}
+
+// Unsigned is unsigned integer types
+type Unsigned interface {
+	~uint | ~uint8 | ~uint16 | ~uint32 | ~uint64 | ~uintptr
+}
+
+type intCodec[T Signed] struct{}
+
+// IntCodec encode signed integer as 8 bytes big-endian with flipped sign bit,
+// so negative numbers are sorted before positive
+func IntCodec[T Signed]() Codec[T] {
+	return intCodec[T]{}
+}
+
+func (intCodec[T]) Encode(v T) ([]byte, error) {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, uint64(v)^(1<<63))
+	return b, nil
+}
+
+func (intCodec[T]) Decode(b []byte) (T, error) {
+	if len(b) != 8 {
+		return 0, fmt.Errorf("slowpoke: int has %d bytes, want 8", len(b))
+	}
+	return T(int64(binary.BigEndian.Uint64(b) ^ (1 << 63))), nil
+}
+
+type uintCodec[T Unsigned] struct{}
+
+// UintCodec encode unsigned integer as 8 bytes big-endian
+func UintCodec[T Unsigned]() Codec[T] {
+	return uintCodec[T]{}
+}
+
+func (uintCodec[T]) Encode(v T) ([]byte, error) {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, uint64(v))
+	return b, nil
+}
+
+func (uintCodec[T]) Decode(b []byte) (T, error) {
+	if len(b) != 8 {
+		return 0, fmt.Errorf("slowpoke: uint has %d bytes, want 8", len(b))
+	}
+	return T(binary.BigEndian.Uint64(b)), nil
+}
+
+type stringCodec struct{}
+
+// StringCodec store string as is
+func StringCodec() Codec[string] {
+	return stringCodec{}
+}
+
+func (stringCodec) Encode(v string) ([]byte, error) {
+	return []byte(v), nil
+}
+
+func (stringCodec) Decode(b []byte) (string, error) {
+	return string(b), nil
+}
+
+type bytesCodec struct{}
+
+// BytesCodec store bytes as is
+func BytesCodec() Codec[[]byte] {
+	return bytesCodec{}
+}
+
+func (bytesCodec) Encode(v []byte) ([]byte, error) {
+	return v, nil
+}
+
+func (bytesCodec) Decode(b []byte) ([]byte, error) {
+	return b, nil
+}
+
+type jsonCodec[T any] struct{}
+
+// JSONCodec encode values with encoding/json
+// Not for keys: order is not kept
+func JSONCodec[T any]() Codec[T] {
+	return jsonCodec[T]{}
+}
+
+func (jsonCodec[T]) Encode(v T) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonCodec[T]) Decode(b []byte) (v T, err error) {
+	err = json.Unmarshal(b, &v)
+	return v, err
+}
+
+type gobCodec[T any] struct{}
+
+// GobCodec encode values with encoding/gob
+// Not for keys: order is not kept
+func GobCodec[T any]() Codec[T] {
+	return gobCodec[T]{}
+}
+
+func (gobCodec[T]) Encode(v T) ([]byte, error) {
+	buf := bytes.Buffer{}
+	err := gob.NewEncoder(&buf).Encode(v)
+	return buf.Bytes(), err
+}
+
+func (gobCodec[T]) Decode(b []byte) (v T, err error) {
+	err = gob.NewDecoder(bytes.NewReader(b)).Decode(&v)
+	return v, err
+}
+
+// Store is typed wrapper of file
+//
+//	posts := slowpoke.NewStore(file, slowpoke.IntCodec[int](), slowpoke.JSONCodec[Post]())
+//	err := posts.Set(42, Post{Content: "Hello"})
+//	ids, err := posts.Keys(10, 0, false)
+type Store[K, V any] struct {
+	file string
+	keys Codec[K]
+	vals Codec[V]
+}
+
+// NewStore return store over file with codecs of keys and values
+func NewStore[K, V any](file string, keyCodec Codec[K], valCodec Codec[V]) *Store[K, V] {
+	return &Store[K, V]{file: file, keys: keyCodec, vals: valCodec}
+}
+
+// File return file of store
+func (s *Store[K, V]) File() string {
+	return s.file
+}
+
+// Set store val and key
+func (s *Store[K, V]) Set(key K, val V) error {
+	k, err := s.keys.Encode(key)
+	if err != nil {
+		return err
+	}
+	v, err := s.vals.Encode(val)
+	if err != nil {
+		return err
+	}
+	return Set(s.file, k, v)
+}
+
+// Get return value by key
+// Return pudge.ErrKeyNotFound if key not exists
+func (s *Store[K, V]) Get(key K) (val V, err error) {
+	k, err := s.keys.Encode(key)
+	if err != nil {
+		return val, err
+	}
+	v, err := Get(s.file, k)
+	if err != nil {
+		return val, err
+	}
+	return s.vals.Decode(v)
+}
+
+// Has return true if key exists
+func (s *Store[K, V]) Has(key K) (bool, error) {
+	k, err := s.keys.Encode(key)
+	if err != nil {
+		return false, err
+	}
+	return Has(s.file, k)
+}
+
+// Delete key
+func (s *Store[K, V]) Delete(key K) error {
+	k, err := s.keys.Encode(key)
+	if err != nil {
+		return err
+	}
+	_, err = Delete(s.file, k)
+	return err
+}
+
+// Count return count of keys
+func (s *Store[K, V]) Count() (uint64, error) {
+	return Count(s.file)
+}
+
+// Keys return keys in ascending/descending order of encoded keys
+// With limit (0 - all keys) and offset
+func (s *Store[K, V]) Keys(limit, offset uint32, asc bool) ([]K, error) {
+	keys, err := collectKeys(NewIterator(s.file, nil), int(limit), int(offset), asc)
+	if err != nil {
+		return nil, err
+	}
+	return s.decodeKeys(keys)
+}
+
+// Range return keys between start and end, nil start or end - no bound
+// See RangeOptions for bounds and order
+func (s *Store[K, V]) Range(start, end *K, opts RangeOptions) ([]K, error) {
+	var from, to []byte
+	var err error
+	if start != nil {
+		if from, err = s.keys.Encode(*start); err != nil {
+			return nil, err
+		}
+	}
+	if end != nil {
+		if to, err = s.keys.Encode(*end); err != nil {
+			return nil, err
+		}
+	}
+	keys, err := Range(s.file, from, to, opts)
+	if err != nil {
+		return nil, err
+	}
+	return s.decodeKeys(keys)
+}
+
+// decodeKeys decode keys of file
+func (s *Store[K, V]) decodeKeys(keys [][]byte) ([]K, error) {
+	result := make([]K, 0, len(keys))
+	for _, k := range keys {
+		key, err := s.keys.Decode(k)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, key)
+	}
+	return result, nil
+}
@@ -0,0 +1,88 @@
+package slowpoke
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/recoilme/pudge"
+)
+
+func TestStoreInt(t *testing.T) {
+	f := "test/TestStoreInt.db"
+	DeleteFile(f)
+	defer Close(f)
+	type Post struct {
+		Id      int
+		Content string
+	}
+	s := NewStore(f, IntCodec[int](), JSONCodec[Post]())
+	for _, id := range []int{10, -1, 256, 0, -300, 42} {
+		ch(s.Set(id, Post{Id: id, Content: fmt.Sprint("Content:", id)}), t)
+	}
+	p, err := s.Get(256)
+	ch(err, t)
+	if p.Id != 256 || p.Content != "Content:256" {
+		t.Error("wrong post", p)
+	}
+	if _, err = s.Get(1); err != pudge.ErrKeyNotFound {
+		t.Error("want not found", err)
+	}
+	keys, err := s.Keys(0, 0, true)
+	ch(err, t)
+	if fmt.Sprint(keys) != "[-300 -1 0 10 42 256]" {
+		t.Error("not numeric order", keys)
+	}
+	keys, err = s.Keys(2, 1, false)
+	ch(err, t)
+	if fmt.Sprint(keys) != "[42 10]" {
+		t.Error("desc", keys)
+	}
+	start, end := -1, 42
+	keys, err = s.Range(&start, &end, RangeOptions{EndInclusive: true})
+	ch(err, t)
+	if fmt.Sprint(keys) != "[-1 0 10 42]" {
+		t.Error("range", keys)
+	}
+	ch(s.Delete(0), t)
+	if has, _ := s.Has(0); has {
+		t.Error("not deleted")
+	}
+	if cnt, _ := s.Count(); cnt != 5 {
+		t.Error("count", cnt)
+	}
+}
+
+func TestStoreCodecs(t *testing.T) {
+	f := "test/TestStoreCodecs.db"
+	DeleteFile(f)
+	defer Close(f)
+	u := NewStore(f, UintCodec[uint32](), StringCodec())
+	// 42 is '*' in last byte of key
+	for _, id := range []uint32{1 << 20, 42, 7} {
+		ch(u.Set(id, fmt.Sprint(id)), t)
+	}
+	keys, err := u.Keys(0, 0, true)
+	ch(err, t)
+	if fmt.Sprint(keys) != "[7 42 1048576]" {
+		t.Error("uint order", keys)
+	}
+	if v, _ := u.Get(42); v != "42" {
+		t.Error("string", v)
+	}
+
+	g := NewStore(f, StringCodec(), GobCodec[map[string]int]())
+	ch(g.Set("gob", map[string]int{"a": 1}), t)
+	if m, err := g.Get("gob"); err != nil || m["a"] != 1 {
+		t.Error("gob", m, err)
+	}
+
+	b := NewStore(f, BytesCodec(), BytesCodec())
+	ch(b.Set([]byte("raw"), []byte{0, 1}), t)
+	if v, err := b.Get([]byte("raw")); err != nil || !bytes.Equal(v, []byte{0, 1}) {
+		t.Error("bytes", v, err)
+	}
+	if _, err := u.Keys(0, 0, true); err == nil {
+		t.Error("decoded not uint key")
+	}
+}
@@ -0,0 +1,307 @@
+package slowpoke
+
+import (
+	"encoding/binary"
+	"os"
+	"time"
+
+	"github.com/recoilme/pudge"
+)
+
+// Expiration time of keys stored in file + ".ttl" (key - 8 bytes unix nano)
+// and loaded in memory on open. Expired keys are not visible
+// and removed in background every sweepInterval.
+
+// sweepInterval is interval of removing of expired keys
+var sweepInterval = time.Second
+
+// ttlName return name of file with expiration time of keys
+func ttlName(file string) string {
+	return file + ".ttl"
+}
+
+// SetWithTTL store val and key, key expire after ttl
+// Expired key is absent for Get/Has/Keys/Count
+// Set of the key without ttl remove expiration
+func SetWithTTL(file string, key, val []byte, ttl time.Duration) error {
+	f, err := openFile(file)
+	if err != nil {
+		return err
+	}
+	return f.put(key, val, now().Add(ttl).UnixNano())
+}
+
+// TTL return time to live of key
+// Return 0 if key has no expiration and pudge.ErrKeyNotFound if key not exists
+func TTL(file string, key []byte) (time.Duration, error) {
+	f, err := openFile(file)
+	if err != nil {
+		return 0, err
+	}
+	f.RLock()
+	defer f.RUnlock()
+	if has, err := f.keyDB().Has(key); err != nil || !has {
+		if err == nil {
+			err = pudge.ErrKeyNotFound
+		}
+		return 0, err
+	}
+	f.ttlMu.Lock()
+	expire, ok := f.ttl[string(key)]
+	f.ttlMu.Unlock()
+	if !ok {
+		return 0, nil
+	}
+	ttl := time.Duration(expire - now().UnixNano())
+	if ttl <= 0 {
+		return 0, pudge.ErrKeyNotFound
+	}
+	return ttl, nil
+}
+
+// loadTTL read expiration time of keys if file has it
+func (f *dbFile) loadTTL() error {
+	if f.opts.InMemory {
+		return nil
+	}
+	name := ttlName(f.name)
+	// compaction of read-only file checked on open
+	if !f.opts.ReadOnly {
+		if err := finishCompaction(name); err != nil {
+			return err
+		}
+	}
+	if _, err := os.Stat(name); os.IsNotExist(err) {
+		return nil
+	}
+	f.ttlMu.Lock()
+	defer f.ttlMu.Unlock()
+	if err := f.openTTL(); err != nil {
+		return err
+	}
+	keys, err := f.ttlDB.Keys(nil, 0, 0, true)
+	if err != nil {
+		return err
+	}
+	// keys of file by stored keys, if keys encrypted
+	var plain map[string][]byte
+	if f.mac != nil {
+		plain = make(map[string][]byte)
+		for _, key := range f.sortedKeys() {
+			plain[string(f.dbKey(key))] = key
+		}
+	}
+	for _, dk := range keys {
+		var b []byte
+		if err = f.ttlDB.Get(dk, &b); err != nil {
+			return err
+		}
+		key := dk
+		if plain != nil {
+			key = plain[string(dk)]
+		}
+		has := key != nil
+		if has {
+			if has, err = f.keyDB().Has(key); err != nil {
+				return err
+			}
+		}
+		if len(b) != 8 || !has {
+			// key removed before crash
+			if !f.opts.ReadOnly {
+				f.ttlDB.Delete(dk)
+			}
+			continue
+		}
+		f.addTTL(key, int64(binary.BigEndian.Uint64(b)))
+	}
+	return nil
+}
+
+// openTTL open file with expiration time and start sweeper, ttlMu must be locked
+func (f *dbFile) openTTL() (err error) {
+	if f.ttlDB != nil {
+		return nil
+	}
+	f.ttlDB, err = f.openDB(ttlName(f.name))
+	if err != nil {
+		return err
+	}
+	f.ttl = make(map[string]int64)
+	f.stopSweep = make(chan struct{})
+	go f.sweeper(f.stopSweep, sweepInterval)
+	return nil
+}
+
+// closeTTL close file with expiration time and stop sweeper, file must be locked
+func (f *dbFile) closeTTL() error {
+	f.ttlMu.Lock()
+	defer f.ttlMu.Unlock()
+	if f.ttlDB == nil {
+		return nil
+	}
+	close(f.stopSweep)
+	err := f.ttlDB.Close()
+	f.ttlDB, f.ttl = nil, nil
+	return err
+}
+
+// addTTL store expiration time in memory, ttlMu must be locked
+func (f *dbFile) addTTL(key []byte, expire int64) {
+	f.ttl[string(key)] = expire
+	if f.nextExpire == 0 || expire < f.nextExpire {
+		f.nextExpire = expire
+	}
+}
+
+// setTTL store expiration time of key
+func (f *dbFile) setTTL(key []byte, expire int64) error {
+	f.ttlMu.Lock()
+	defer f.ttlMu.Unlock()
+	if err := f.openTTL(); err != nil {
+		return err
+	}
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, uint64(expire))
+	if err := f.ttlDB.Set(f.dbKey(key), b); err != nil {
+		return err
+	}
+	f.addTTL(key, expire)
+	return nil
+}
+
+// clearTTL remove expiration time of key if any
+func (f *dbFile) clearTTL(key []byte) error {
+	f.ttlMu.Lock()
+	defer f.ttlMu.Unlock()
+	if _, ok := f.ttl[string(key)]; !ok {
+		return nil
+	}
+	delete(f.ttl, string(key))
+	err := f.ttlDB.Delete(f.dbKey(key))
+	if err == pudge.ErrKeyNotFound {
+		err = nil
+	}
+	return err
+}
+
+// expired return true if key has expired
+func (f *dbFile) expired(key []byte) bool {
+	f.ttlMu.Lock()
+	defer f.ttlMu.Unlock()
+	expire, ok := f.ttl[string(key)]
+	return ok && expire <= now().UnixNano()
+}
+
+// expiredKeys return set of expired keys
+// Fast if no keys expired
+func (f *dbFile) expiredKeys() map[string]struct{} {
+	f.ttlMu.Lock()
+	defer f.ttlMu.Unlock()
+	t := now().UnixNano()
+	if f.nextExpire == 0 || f.nextExpire > t {
+		return nil
+	}
+	keys := make(map[string]struct{})
+	f.nextExpire = 0
+	for key, expire := range f.ttl {
+		if expire <= t {
+			keys[key] = struct{}{}
+		} else if f.nextExpire == 0 || expire < f.nextExpire {
+			f.nextExpire = expire
+		}
+	}
+	if len(keys) > 0 && (f.nextExpire == 0 || f.nextExpire > t) {
+		// expired keys will be checked again until removed
+		f.nextExpire = t
+	}
+	return keys
+}
+
+// sweeper remove expired keys every interval
+func (f *dbFile) sweeper(stop chan struct{}, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+		f.sweep()
+	}
+}
+
+// sweep remove expired keys, expired keys of read-only file are only hidden
+func (f *dbFile) sweep() {
+	if f.opts.ReadOnly {
+		return
+	}
+	for key := range f.expiredKeys() {
+		f.removeExpired([]byte(key))
+	}
+}
+
+// removeExpired remove key if it's still expired
+func (f *dbFile) removeExpired(key []byte) {
+	unlock := lockKey(f.name, key)
+	defer unlock()
+	f.RLock()
+	closed := f.closed
+	if !closed && !indexed(f.name) {
+		if f.expired(key) {
+			if err := f.delete(key); err == pudge.ErrKeyNotFound {
+				f.clearTTL(key)
+			}
+		}
+		f.RUnlock()
+		return
+	}
+	f.RUnlock()
+	if !closed {
+		commitFunc([]string{f.name}, func() (map[string]map[string]txOp, error) {
+			return deleteOps(f.name, key, true)
+		})
+	}
+}
+
+// rewriteTTL rewrite file with expiration time of keys, file must be locked
+// Called by compaction for reclaim space of removed keys
+func (f *dbFile) rewriteTTL() error {
+	f.ttlMu.Lock()
+	defer f.ttlMu.Unlock()
+	if f.ttlDB == nil {
+		return nil
+	}
+	name := ttlName(f.name)
+	tmp := compactName(name)
+	removeCompaction(name)
+	db, err := pudge.Open(tmp, f.config())
+	if err != nil {
+		return err
+	}
+	b := make([]byte, 8)
+	for key, expire := range f.ttl {
+		binary.BigEndian.PutUint64(b, uint64(expire))
+		if err = db.Set(f.dbKey([]byte(key)), b); err != nil {
+			break
+		}
+	}
+	if e := db.Close(); err == nil {
+		err = e
+	}
+	if err == nil {
+		err = f.ttlDB.Close()
+	}
+	if err != nil {
+		removeCompaction(name)
+		return err
+	}
+	if err = markCompacted(name, f.opts.FileMode); err == nil {
+		err = finishCompaction(name)
+	}
+	if err == nil {
+		f.ttlDB, err = pudge.Open(name, f.config())
+	}
+	return err
+}
@@ -0,0 +1,138 @@
+package slowpoke
+
+import (
+	"bytes"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/recoilme/pudge"
+)
+
+// testClock is clock for tests, moved by hand when stopped
+type testClock struct {
+	sync.Mutex
+	t time.Time
+}
+
+var clock = &testClock{}
+
+func init() {
+	now = clock.now
+}
+
+func (c *testClock) now() time.Time {
+	c.Lock()
+	defer c.Unlock()
+	if c.t.IsZero() {
+		return time.Now()
+	}
+	return c.t
+}
+
+// stop clock, return func to start it again
+func (c *testClock) stop() func() {
+	c.Lock()
+	c.t = time.Now()
+	c.Unlock()
+	return func() {
+		c.Lock()
+		c.t = time.Time{}
+		c.Unlock()
+	}
+}
+
+func (c *testClock) add(d time.Duration) {
+	c.Lock()
+	c.t = c.t.Add(d)
+	c.Unlock()
+}
+
+func TestTTL(t *testing.T) {
+	f := "test/TestTTL.db"
+	DeleteFile(f)
+	defer clock.stop()()
+	defer Close(f)
+
+	ch(Set(f, []byte("a"), []byte("a")), t)
+	ch(SetWithTTL(f, []byte("b"), []byte("b"), time.Minute), t)
+	ch(SetWithTTL(f, []byte("c"), []byte("c"), time.Hour), t)
+	if ttl, err := TTL(f, []byte("a")); err != nil || ttl != 0 {
+		t.Error("ttl of a", ttl, err)
+	}
+	if ttl, err := TTL(f, []byte("b")); err != nil || ttl != time.Minute {
+		t.Error("ttl of b", ttl, err)
+	}
+	if _, err := TTL(f, []byte("d")); err != pudge.ErrKeyNotFound {
+		t.Error("ttl of d", err)
+	}
+
+	clock.add(2 * time.Minute)
+	if _, err := Get(f, []byte("b")); err != pudge.ErrKeyNotFound {
+		t.Error("expired key found", err)
+	}
+	if has, _ := Has(f, []byte("b")); has {
+		t.Error("has expired key")
+	}
+	if _, err := TTL(f, []byte("b")); err != pudge.ErrKeyNotFound {
+		t.Error("ttl of expired key", err)
+	}
+	if cnt, _ := Count(f); cnt != 2 {
+		t.Error("count with expired key", cnt)
+	}
+	keys, err := Keys(f, nil, 0, 0, true)
+	ch(err, t)
+	if len(keys) != 2 || string(keys[0]) != "a" || string(keys[1]) != "c" {
+		t.Error("keys with expired key", keys)
+	}
+	keys, err = Keys(f, nil, 1, 1, true)
+	ch(err, t)
+	if len(keys) != 1 || string(keys[0]) != "c" {
+		t.Error("keys with limit and expired key", keys)
+	}
+
+	// Set without ttl remove expiration
+	ch(Set(f, []byte("c"), []byte("c2")), t)
+	clock.add(2 * time.Hour)
+	if v, err := Get(f, []byte("c")); err != nil || !bytes.Equal(v, []byte("c2")) {
+		t.Error("key without ttl expired", v, err)
+	}
+
+	// expiration survive reopen
+	ch(SetWithTTL(f, []byte("d"), []byte("d"), time.Minute), t)
+	Close(f)
+	if ttl, err := TTL(f, []byte("d")); err != nil || ttl != time.Minute {
+		t.Error("ttl after reopen", ttl, err)
+	}
+	clock.add(time.Minute)
+	if has, _ := Has(f, []byte("d")); has {
+		t.Error("has expired key after reopen")
+	}
+}
+
+func TestTTLSweep(t *testing.T) {
+	f := "test/TestTTLSweep.db"
+	DeleteFile(f)
+	defer clock.stop()()
+	interval := sweepInterval
+	sweepInterval = 10 * time.Millisecond
+	defer func() { sweepInterval = interval }()
+	defer Close(f)
+
+	for i := 0; i < 10; i++ {
+		ch(SetWithTTL(f, []byte{byte(i)}, []byte{byte(i)}, time.Duration(i+1)*time.Minute), t)
+	}
+	clock.add(5 * time.Minute)
+	time.Sleep(100 * time.Millisecond)
+	fl, err := openFile(f)
+	ch(err, t)
+	// expired keys removed from file
+	if cnt, _ := fl.db.Count(); cnt != 5 {
+		t.Error("expired keys not removed", cnt)
+	}
+	ch(Compact(f), t)
+	clock.add(time.Minute)
+	if cnt, _ := Count(f); cnt != 4 {
+		t.Error("expiration lost after compaction", cnt)
+	}
+}
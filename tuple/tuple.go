@@ -0,0 +1,233 @@
+// Package tuple implements order-preserving encoding of composite keys
+//
+// Packed tuples are sorted as bytes in the same order as tuples:
+// element by element, shorter tuple first. Elements of different types
+// are ordered by type: nil, bytes, string, int, uint, float, bool, time.
+//
+//	key, _ := tuple.Pack("tag", 2, time.Now())
+//	slowpoke.Set(tags, key, nil)
+//	start, end, _ := tuple.PrefixRange("tag", 2)
+//	keys, _ := slowpoke.Range(tags, start, end, slowpoke.RangeOptions{})
+package tuple
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/recoilme/slowpoke"
+)
+
+// Tuple is list of elements
+// Supported types: nil, []byte, string, signed and unsigned integers,
+// float32, float64, bool and time.Time
+type Tuple []interface{}
+
+// type codes
+const (
+	codeNil    = 0x00
+	codeBytes  = 0x01
+	codeString = 0x02
+	codeInt    = 0x14
+	codeUint   = 0x15
+	codeFloat  = 0x21
+	codeFalse  = 0x26
+	codeTrue   = 0x27
+	codeTime   = 0x33
+)
+
+// ErrInvalid is returned by Unpack for malformed bytes
+var ErrInvalid = errors.New("tuple: invalid packed tuple")
+
+// Pack encode elements
+func Pack(elems ...interface{}) ([]byte, error) {
+	return Tuple(elems).Pack()
+}
+
+// Pack encode tuple
+func (t Tuple) Pack() ([]byte, error) {
+	buf := make([]byte, 0, 16*len(t))
+	for i, e := range t {
+		var err error
+		if buf, err = appendElem(buf, e); err != nil {
+			return nil, fmt.Errorf("tuple: element %d: %v", i, err)
+		}
+	}
+	return buf, nil
+}
+
+// PrefixRange return range of packed tuples starting with elements
+// Use it with slowpoke.Range (start included, end not included)
+func PrefixRange(elems ...interface{}) (start, end []byte, err error) {
+	p, err := Pack(elems...)
+	if err != nil {
+		return nil, nil, err
+	}
+	start = append(append(make([]byte, 0, len(p)+1), p...), 0x00)
+	end = append(append(make([]byte, 0, len(p)+1), p...), 0xff)
+	return start, end, nil
+}
+
+func appendElem(buf []byte, e interface{}) ([]byte, error) {
+	switch v := e.(type) {
+	case nil:
+		return append(buf, codeNil), nil
+	case []byte:
+		return appendEscaped(append(buf, codeBytes), v), nil
+	case string:
+		return appendEscaped(append(buf, codeString), []byte(v)), nil
+	case int:
+		return appendInt(buf, int64(v)), nil
+	case int8:
+		return appendInt(buf, int64(v)), nil
+	case int16:
+		return appendInt(buf, int64(v)), nil
+	case int32:
+		return appendInt(buf, int64(v)), nil
+	case int64:
+		return appendInt(buf, v), nil
+	case uint:
+		return appendUint(buf, codeUint, uint64(v)), nil
+	case uint8:
+		return appendUint(buf, codeUint, uint64(v)), nil
+	case uint16:
+		return appendUint(buf, codeUint, uint64(v)), nil
+	case uint32:
+		return appendUint(buf, codeUint, uint64(v)), nil
+	case uint64:
+		return appendUint(buf, codeUint, v), nil
+	case float32:
+		return appendFloat(buf, float64(v)), nil
+	case float64:
+		return appendFloat(buf, v), nil
+	case bool:
+		if v {
+			return append(buf, codeTrue), nil
+		}
+		return append(buf, codeFalse), nil
+	case time.Time:
+		return appendUint(buf, codeTime, uint64(v.UnixNano())^(1<<63)), nil
+	}
+	return nil, fmt.Errorf("unsupported type %T", e)
+}
+
+// appendEscaped append bytes terminated by 0x00, 0x00 in bytes is escaped as 0x00 0xff
+func appendEscaped(buf, b []byte) []byte {
+	for _, c := range b {
+		buf = append(buf, c)
+		if c == 0x00 {
+			buf = append(buf, 0xff)
+		}
+	}
+	return append(buf, 0x00)
+}
+
+func appendUint(buf []byte, code byte, v uint64) []byte {
+	buf = append(buf, code, 0, 0, 0, 0, 0, 0, 0, 0)
+	binary.BigEndian.PutUint64(buf[len(buf)-8:], v)
+	return buf
+}
+
+// appendInt flip sign bit, so negative numbers are sorted before positive
+func appendInt(buf []byte, v int64) []byte {
+	return appendUint(buf, codeInt, uint64(v)^(1<<63))
+}
+
+// appendFloat flip all bits of negative numbers and sign bit of positive
+func appendFloat(buf []byte, v float64) []byte {
+	u := math.Float64bits(v)
+	if u&(1<<63) != 0 {
+		u = ^u
+	} else {
+		u ^= 1 << 63
+	}
+	return appendUint(buf, codeFloat, u)
+}
+
+// Unpack decode packed tuple
+// Integers are decoded as int64 and uint64, floats as float64, time in UTC
+func Unpack(b []byte) (Tuple, error) {
+	t := Tuple{}
+	for len(b) > 0 {
+		code := b[0]
+		b = b[1:]
+		switch code {
+		case codeNil:
+			t = append(t, nil)
+		case codeBytes, codeString:
+			v, n := unescape(b)
+			if n < 0 {
+				return nil, ErrInvalid
+			}
+			b = b[n:]
+			if code == codeString {
+				t = append(t, string(v))
+			} else {
+				t = append(t, v)
+			}
+		case codeInt, codeUint, codeFloat, codeTime:
+			if len(b) < 8 {
+				return nil, ErrInvalid
+			}
+			u := binary.BigEndian.Uint64(b)
+			b = b[8:]
+			switch code {
+			case codeInt:
+				t = append(t, int64(u^(1<<63)))
+			case codeUint:
+				t = append(t, u)
+			case codeFloat:
+				if u&(1<<63) != 0 {
+					u ^= 1 << 63
+				} else {
+					u = ^u
+				}
+				t = append(t, math.Float64frombits(u))
+			case codeTime:
+				t = append(t, time.Unix(0, int64(u^(1<<63))).UTC())
+			}
+		case codeFalse:
+			t = append(t, false)
+		case codeTrue:
+			t = append(t, true)
+		default:
+			return nil, ErrInvalid
+		}
+	}
+	return t, nil
+}
+
+// unescape return bytes until terminator and count of read bytes, -1 if no terminator
+func unescape(b []byte) ([]byte, int) {
+	v := make([]byte, 0, len(b))
+	for i := 0; i < len(b); i++ {
+		if b[i] != 0x00 {
+			v = append(v, b[i])
+			continue
+		}
+		if i+1 < len(b) && b[i+1] == 0xff {
+			v = append(v, 0x00)
+			i++
+			continue
+		}
+		return v, i + 1
+	}
+	return nil, -1
+}
+
+type codec struct{}
+
+// Codec return codec of tuples for slowpoke.NewStore
+func Codec() slowpoke.Codec[Tuple] {
+	return codec{}
+}
+
+func (codec) Encode(t Tuple) ([]byte, error) {
+	return t.Pack()
+}
+
+func (codec) Decode(b []byte) (Tuple, error) {
+	return Unpack(b)
+}
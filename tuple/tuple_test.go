@@ -0,0 +1,137 @@
+package tuple
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/recoilme/slowpoke"
+)
+
+func TestOrder(t *testing.T) {
+	ts := time.Date(2020, 1, 2, 3, 4, 5, 6, time.UTC)
+	// tuples in ascending order
+	tuples := []Tuple{
+		{},
+		{nil},
+		{[]byte{0}},
+		{[]byte{0, 0}},
+		{[]byte{0, 1}},
+		{[]byte{1}},
+		{""},
+		{"a"},
+		{"a", nil},
+		{"a", "b"},
+		{"a", -1},
+		{"a", 0},
+		{"a", 2},
+		{"a", 10},
+		{"a\x00"},
+		{"a\x00", "b"},
+		{"ab"},
+		{"b"},
+		{math.MinInt64},
+		{-256},
+		{-1},
+		{0},
+		{1},
+		{255},
+		{256},
+		{math.MaxInt64},
+		{uint(0)},
+		{uint64(math.MaxUint64)},
+		{math.Inf(-1)},
+		{-1.5},
+		{-0.5},
+		{0.0},
+		{0.5},
+		{1.5},
+		{math.Inf(1)},
+		{false},
+		{true},
+		{ts.Add(-time.Hour)},
+		{ts},
+		{ts, "a"},
+		{ts.Add(time.Nanosecond)},
+	}
+	var prev []byte
+	for i, tup := range tuples {
+		b, err := tup.Pack()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if i > 0 && bytes.Compare(prev, b) >= 0 {
+			t.Errorf("%v not less than %v", tuples[i-1], tup)
+		}
+		prev = b
+	}
+}
+
+func TestUnpack(t *testing.T) {
+	ts := time.Date(2020, 1, 2, 3, 4, 5, 6, time.UTC)
+	in := Tuple{nil, []byte{0, 1, 0}, "s\x00", int8(-3), 42, uint16(7), float32(1.5), -2.25, true, false, ts}
+	want := Tuple{nil, []byte{0, 1, 0}, "s\x00", int64(-3), int64(42), uint64(7), 1.5, -2.25, true, false, ts}
+	b, err := in.Pack()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := Unpack(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %#v, want %#v", got, want)
+	}
+	if _, err = Unpack(b[:len(b)-1]); err != ErrInvalid {
+		t.Error("want ErrInvalid", err)
+	}
+	if _, err = Pack(struct{}{}); err == nil {
+		t.Error("packed struct")
+	}
+}
+
+func TestSlowpoke(t *testing.T) {
+	f := "test/TestTuple.db"
+	slowpoke.DeleteFile(f)
+	defer slowpoke.Close(f)
+	for i := 0; i < 40; i++ {
+		key, err := Pack(fmt.Sprint(i/10), i)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = slowpoke.Set(f, key, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	start, end, err := PrefixRange("2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys, err := slowpoke.Range(f, start, end, slowpoke.RangeOptions{Desc: true, Limit: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []int64
+	for _, k := range keys {
+		tup, err := Unpack(k)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, tup[1].(int64))
+	}
+	if fmt.Sprint(ids) != "[29 28 27]" {
+		t.Error("wrong ids", ids)
+	}
+
+	s := slowpoke.NewStore(f, Codec(), slowpoke.BytesCodec())
+	tuples, err := s.Keys(2, 0, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(tuples) != "[[0 0] [0 1]]" {
+		t.Error("store keys", tuples)
+	}
+}
@@ -0,0 +1,85 @@
+package slowpoke
+
+import (
+	"errors"
+
+	"github.com/recoilme/pudge"
+)
+
+// ErrTxDone returned on use of committed or rolled back transaction or batch
+var ErrTxDone = errors.New("slowpoke: transaction has already been committed or rolled back")
+
+// Tx is a transaction on one file
+// Writes are buffered in memory and applied all-or-nothing on Commit
+// Get inside transaction see own writes
+// Tx is not safe for concurrent use
+type Tx struct {
+	file string
+	ops  map[string]txOp
+	done bool
+}
+
+// Begin start transaction on file
+// File will be opened (created) if needed
+func Begin(file string) (*Tx, error) {
+	if _, err := openFile(file); err != nil {
+		return nil, err
+	}
+	return &Tx{file: file, ops: make(map[string]txOp)}, nil
+}
+
+// Set store val and key in transaction
+func (tx *Tx) Set(key, val []byte) error {
+	if tx.done {
+		return ErrTxDone
+	}
+	tx.ops[string(key)] = newSetOp(tx.file, key, val)
+	return nil
+}
+
+// Delete key in transaction
+func (tx *Tx) Delete(key []byte) error {
+	if tx.done {
+		return ErrTxDone
+	}
+	tx.ops[string(key)] = newDeleteOp(tx.file, key)
+	return nil
+}
+
+// Get return value by key, written in transaction or stored in file
+func (tx *Tx) Get(key []byte) ([]byte, error) {
+	if tx.done {
+		return nil, ErrTxDone
+	}
+	if op, ok := tx.ops[string(key)]; ok {
+		if op.del {
+			return nil, pudge.ErrKeyNotFound
+		}
+		return append([]byte{}, op.val...), nil
+	}
+	return Get(tx.file, key)
+}
+
+// Rollback discard all writes of transaction
+func (tx *Tx) Rollback() error {
+	if tx.done {
+		return ErrTxDone
+	}
+	tx.done = true
+	tx.ops = nil
+	return nil
+}
+
+// Commit apply all writes of transaction
+// Writes stored in journal first, so after crash
+// transaction will be applied on next open or not applied at all
+// Readers and writers of file wait while transaction applied
+func (tx *Tx) Commit() error {
+	if tx.done {
+		return ErrTxDone
+	}
+	tx.done = true
+	ops := tx.ops
+	tx.ops = nil
+	return commit(map[string]map[string]txOp{tx.file: ops})
+}
@@ -0,0 +1,135 @@
+package slowpoke
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/recoilme/pudge"
+)
+
+func TestTx(t *testing.T) {
+	f := "test/TestTx.db"
+	DeleteFile(f)
+	defer Close(f)
+	ch(Set(f, []byte("1"), []byte("1")), t)
+	ch(Set(f, []byte("2"), []byte("2")), t)
+
+	tx, err := Begin(f)
+	ch(err, t)
+	ch(tx.Set([]byte("1"), []byte("11")), t)
+	ch(tx.Delete([]byte("2")), t)
+	ch(tx.Set([]byte("3"), []byte("33")), t)
+	// read your writes
+	if v, _ := tx.Get([]byte("1")); !bytes.Equal(v, []byte("11")) {
+		t.Error("tx not see own write", string(v))
+	}
+	if _, err = tx.Get([]byte("2")); err != pudge.ErrKeyNotFound {
+		t.Error("tx not see own delete", err)
+	}
+	// not visible outside before commit
+	if v, _ := Get(f, []byte("1")); !bytes.Equal(v, []byte("1")) {
+		t.Error("tx write visible before commit", string(v))
+	}
+	ch(tx.Commit(), t)
+	if tx.Commit() != ErrTxDone {
+		t.Error("commit twice")
+	}
+	Close(f)
+	if v, _ := Get(f, []byte("1")); !bytes.Equal(v, []byte("11")) {
+		t.Error("not committed", string(v))
+	}
+	if has, _ := Has(f, []byte("2")); has {
+		t.Error("not deleted")
+	}
+	if cnt, _ := Count(f); cnt != 2 {
+		t.Error("count!=2", cnt)
+	}
+
+	tx, err = Begin(f)
+	ch(err, t)
+	ch(tx.Set([]byte("4"), []byte("4")), t)
+	ch(tx.Rollback(), t)
+	if has, _ := Has(f, []byte("4")); has {
+		t.Error("rollback stored key")
+	}
+	if tx.Set([]byte("4"), nil) != ErrTxDone {
+		t.Error("set after rollback")
+	}
+	if _, err = os.Stat(journalName(f)); !os.IsNotExist(err) {
+		t.Error("journal not removed", err)
+	}
+}
+
+func TestTxCrash(t *testing.T) {
+	f := "test/TestTxCrash.db"
+	DeleteFile(f)
+	defer Close(f)
+	ch(Set(f, []byte("a"), []byte("old")), t)
+	j := newJournal(f)
+	j.ops = []txOp{
+		{file: f, key: []byte("a"), val: []byte("new")},
+		{file: f, key: []byte("b"), val: []byte("new")},
+		{file: f, key: []byte("c"), del: true},
+	}
+	// crash after journal written and first op applied
+	ch(writeJournal(f, j, 0666), t)
+	db, _ := Open(f, nil)
+	ch(db.Set([]byte("c"), []byte("new")), t)
+	db.Close()
+
+	for _, k := range []string{"a", "b"} {
+		if v, _ := Get(f, []byte(k)); !bytes.Equal(v, []byte("new")) {
+			t.Error("not replayed", k, string(v))
+		}
+	}
+	if has, _ := Has(f, []byte("c")); has {
+		t.Error("delete not replayed")
+	}
+	if _, err := os.Stat(journalName(f)); !os.IsNotExist(err) {
+		t.Error("journal not removed", err)
+	}
+
+	// crash while journal written
+	Close(f)
+	ch(ioutil.WriteFile(journalName(f)+".tmp", []byte("spwal1\x00\x01"), 0666), t)
+	if v, _ := Get(f, []byte("a")); !bytes.Equal(v, []byte("new")) {
+		t.Error("partial journal applied", string(v))
+	}
+	if _, err := os.Stat(journalName(f) + ".tmp"); !os.IsNotExist(err) {
+		t.Error("tmp journal not removed", err)
+	}
+}
+
+func TestTxAsync(t *testing.T) {
+	f := "test/TestTxAsync.db"
+	DeleteFile(f)
+	defer Close(f)
+	var wg sync.WaitGroup
+	// every tx store a=1 and b=-1 (as byte) together,
+	// reader must see both keys with sum 0 or none of them
+	for i := 0; i < 20; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			tx, err := Begin(f)
+			ch(err, t)
+			ch(tx.Set([]byte("a"), []byte{1}), t)
+			ch(tx.Set([]byte("b"), []byte{255}), t)
+			ch(tx.Commit(), t)
+		}()
+		go func() {
+			defer wg.Done()
+			res := Gets(f, [][]byte{[]byte("a"), []byte("b")})
+			switch {
+			case len(res) == 2:
+				t.Error("partial commit visible")
+			case len(res) == 4 && res[1][0]+res[3][0] != 0:
+				t.Error("sum != 0", res[1], res[3])
+			}
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,237 @@
+package slowpoke
+
+import (
+	"fmt"
+	"os"
+	"sort"
+)
+
+// Problem is inconsistency of value and index files found by Verify
+type Problem struct {
+	File   string // value or index file
+	Offset int64  // offset of broken data in File
+	Key    []byte // key of broken record, nil for torn tail
+	Reason string
+}
+
+func (p Problem) String() string {
+	if p.Key == nil {
+		return fmt.Sprintf("%s at %d: %s", p.File, p.Offset, p.Reason)
+	}
+	return fmt.Sprintf("%s at %d: key %q: %s", p.File, p.Offset, p.Key, p.Reason)
+}
+
+// VerifyReport is result of Verify and Repair
+type VerifyReport struct {
+	Keys     int       // count of consistent keys
+	Problems []Problem // problems found, Repair fix them
+}
+
+// OK return true if no problems found
+func (r *VerifyReport) OK() bool {
+	return len(r.Problems) == 0
+}
+
+// checked is result of check of value and index files
+type checked struct {
+	live     []*indexRecord // live records with values in value file, by position
+	end      int64          // size of complete records of index file
+	valueEnd int64          // end of last value of live records
+	problems []Problem
+}
+
+// check cross-check index file of name with value file
+func check(name string) (*checked, error) {
+	vi, err := os.Stat(name)
+	if err != nil {
+		return nil, err
+	}
+	ii, err := os.Stat(name + ".idx")
+	if err != nil {
+		return nil, err
+	}
+	ft, err := readHeader(name)
+	if err != nil {
+		return nil, err
+	}
+	c := &checked{valueEnd: ft.dataStart()}
+	live := make(map[string]*indexRecord)
+	c.end, err = readIndex(name, func(r *indexRecord) error {
+		if r.del {
+			delete(live, string(r.key))
+		} else {
+			live[string(r.key)] = r
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if c.end < ii.Size() {
+		c.problems = append(c.problems, Problem{File: name + ".idx", Offset: c.end, Reason: "torn tail"})
+	}
+	records := make([]*indexRecord, 0, len(live))
+	for _, r := range live {
+		records = append(records, r)
+	}
+	sort.Slice(records, func(i, k int) bool {
+		return records[i].pos < records[k].pos
+	})
+	fd, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer fd.Close()
+	for _, r := range records {
+		end := int64(r.seek) + int64(r.size)
+		if end > vi.Size() {
+			c.problems = append(c.problems, Problem{File: name, Offset: int64(r.seek), Key: r.key,
+				Reason: fmt.Sprintf("value of %d bytes out of file of %d bytes", r.size, vi.Size())})
+			continue
+		}
+		if ft.flags&flagChecksum != 0 {
+			b := make([]byte, r.size)
+			if _, err = fd.ReadAt(b, int64(r.seek)); err != nil {
+				return nil, err
+			}
+			if _, ok := ft.decodeValue(r.key, b); !ok {
+				c.problems = append(c.problems, Problem{File: name, Offset: int64(r.seek), Key: r.key,
+					Reason: "checksum mismatch"})
+				continue
+			}
+		}
+		if end > c.valueEnd {
+			c.valueEnd = end
+		}
+		c.live = append(c.live, r)
+	}
+	return c, nil
+}
+
+// verifyFiles check file and its expiration time, file must be locked
+// If repair - rewrite index of every file with problems
+func verifyFiles(file string, repair bool) (*VerifyReport, error) {
+	report := &VerifyReport{}
+	for _, name := range []string{file, ttlName(file)} {
+		if name != file {
+			if _, err := os.Stat(name); os.IsNotExist(err) {
+				continue
+			}
+		}
+		c, err := check(name)
+		if err != nil {
+			return nil, err
+		}
+		if name == file {
+			report.Keys = len(c.live)
+		}
+		report.Problems = append(report.Problems, c.problems...)
+		if repair && len(c.problems) > 0 {
+			if err = rewriteIndex(name, c); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return report, nil
+}
+
+// rewriteIndex write index of live records and truncate value file
+// after last value. Index written in new file and renamed
+func rewriteIndex(name string, c *checked) error {
+	tmp := name + ".idx.repair"
+	fi, err := os.Stat(name + ".idx")
+	if err != nil {
+		return err
+	}
+	// new index has mode of replaced index
+	fd, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, fi.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	for _, r := range c.live {
+		if _, err = fd.Write(r.encode()); err != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = fd.Sync()
+	}
+	if e := fd.Close(); err == nil {
+		err = e
+	}
+	if err == nil {
+		err = os.Rename(tmp, name+".idx")
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	syncDir(name)
+	return os.Truncate(name, c.valueEnd)
+}
+
+// Verify cross-check every index record of file with value file:
+// complete records, values inside of value file, checksums of values
+// Expiration time of keys (file + ".ttl") is checked too
+// Writes of opened file wait while file checked
+func Verify(file string) (*VerifyReport, error) {
+	for {
+		files.RLock()
+		f, ok := files.m[file]
+		files.RUnlock()
+		if ok {
+			f.Lock()
+			defer f.Unlock()
+			if f.opts.InMemory {
+				cnt, err := f.db.Count()
+				return &VerifyReport{Keys: cnt}, err
+			}
+			return verifyFiles(file, false)
+		}
+		// file is not opened while checked
+		release, err := reserveFile(file)
+		if err == ErrInUse {
+			// opened meanwhile
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		defer release()
+		lf := &dbFile{name: file, opts: Options{ReadOnly: true}.withDefaults()}
+		if err := lf.acquireLock(); err != nil {
+			return nil, err
+		}
+		defer lf.releaseLock()
+		return verifyFiles(file, false)
+	}
+}
+
+// Repair rebuild index of file with problems found by Verify:
+// torn tail is truncated, keys with values out of value file
+// or with wrong checksum are removed
+// Return problems found. File is closed while repaired,
+// Return ErrInUse if file opened by DB
+func Repair(file string) (*VerifyReport, error) {
+	if inUse(file) {
+		return nil, ErrInUse
+	}
+	if _, err := os.Stat(file); err != nil {
+		return nil, err
+	}
+	if _, err := closeFile(file); err != nil {
+		return nil, err
+	}
+	// opened again while closed, ErrInUse
+	release, err := reserveFile(file)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	lf := &dbFile{name: file, opts: Options{}.withDefaults()}
+	if err := lf.acquireLock(); err != nil {
+		return nil, err
+	}
+	defer lf.releaseLock()
+	return verifyFiles(file, true)
+}
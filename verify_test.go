@@ -0,0 +1,118 @@
+package slowpoke
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func TestVerifyRepair(t *testing.T) {
+	f := "test/TestVerifyRepair.db"
+	DeleteFile(f)
+	defer DeleteFile(f)
+	for _, k := range []string{"a", "b", "c", "d"} {
+		ch(Set(f, []byte(k), []byte(k+k)), t)
+	}
+	ch(Set(f, []byte("a"), []byte("a2")), t)
+	ch(Set(f, []byte("e"), []byte("eeeeeeeeee")), t)
+	Delete(f, []byte("b"))
+	r, err := Verify(f)
+	ch(err, t)
+	if !r.OK() || r.Keys != 4 {
+		t.Error("verify of good file", r.Keys, r.Problems)
+	}
+	ch(Close(f), t)
+
+	// value of last key is partially written
+	fi, err := os.Stat(f)
+	ch(err, t)
+	ch(os.Truncate(f, fi.Size()-5), t)
+	r, err = Verify(f)
+	ch(err, t)
+	if r.OK() || r.Keys != 3 || string(r.Problems[0].Key) != "e" {
+		t.Error("verify of torn value", r.Keys, r.Problems)
+	}
+
+	// last record of index is partially written
+	fi, err = os.Stat(f + ".idx")
+	ch(err, t)
+	ch(os.Truncate(f+".idx", fi.Size()-3), t)
+	r, err = Verify(f)
+	ch(err, t)
+	if len(r.Problems) != 2 || r.Problems[0].Key != nil || r.Problems[0].Offset != fi.Size()-indexHeaderSize-1 {
+		t.Error("verify of torn index", r.Problems)
+	}
+
+	r, err = Repair(f)
+	ch(err, t)
+	if len(r.Problems) != 2 {
+		t.Error("repair", r.Problems)
+	}
+	r, err = Verify(f)
+	ch(err, t)
+	if !r.OK() || r.Keys != 4 {
+		t.Error("verify after repair", r.Keys, r.Problems)
+	}
+	// b was deleted by torn record, so it is back
+	for _, k := range []string{"a", "b", "c", "d", "e"} {
+		v, err := Get(f, []byte(k))
+		switch k {
+		case "a":
+			if string(v) != "a2" {
+				t.Error("value of a", string(v), err)
+			}
+		case "b":
+			if string(v) != "bb" {
+				t.Error("value of b", string(v), err)
+			}
+		case "e":
+			if err == nil {
+				t.Error("torn key found")
+			}
+		}
+	}
+	ch(Set(f, []byte("f"), []byte("f")), t)
+
+	db, err := Open(f, nil)
+	ch(err, t)
+	if _, err = Repair(f); err != ErrInUse {
+		t.Error("repair of file in use", err)
+	}
+	ch(db.Close(), t)
+}
+
+func TestReserveFile(t *testing.T) {
+	f := "test/TestReserveFile.db"
+	other := "test/TestReserveFile2.db"
+	DeleteFile(f)
+	DeleteFile(other)
+	defer DeleteFile(f)
+	defer DeleteFile(other)
+	ch(Set(f, []byte("a"), []byte("1")), t)
+	ch(Close(f), t)
+
+	// file checked by Verify don't block other files
+	release, err := reserveFile(f)
+	ch(err, t)
+	ch(Set(other, []byte("a"), []byte("1")), t)
+	opened := make(chan error, 1)
+	go func() {
+		_, err := Get(f, []byte("a"))
+		opened <- err
+	}()
+	select {
+	case <-opened:
+		t.Error("reserved file opened")
+	case <-time.After(100 * time.Millisecond):
+	}
+	release()
+	ch(<-opened, t)
+	if _, err = reserveFile(f); err != ErrInUse {
+		t.Error("reserve of opened file", err)
+	}
+	r, err := Verify(f)
+	ch(err, t)
+	if !r.OK() || r.Keys != 1 {
+		t.Error("verify of opened file", r.Keys, r.Problems)
+	}
+}
@@ -0,0 +1,313 @@
+package slowpoke
+
+import (
+	"bytes"
+	"errors"
+	"sort"
+	"sync"
+
+	"github.com/recoilme/pudge"
+)
+
+// View keep sorted keys of file at the moment it's created, and writers
+// save old value of key in all views before first change of the key,
+// so view read saved value or value in file if key is not changed.
+// Views cost nothing if not used, but view keep old values of changed
+// keys in memory until released.
+
+// ErrReleased returned on use of released View
+var ErrReleased = errors.New("slowpoke: view is released")
+
+// views contains views of files, not released
+var views = struct {
+	sync.RWMutex
+	m map[string][]*View
+}{m: make(map[string][]*View)}
+
+// viewsOf return views of file
+func viewsOf(file string) []*View {
+	views.RLock()
+	defer views.RUnlock()
+	return views.m[file]
+}
+
+// oldValue is value of key at the moment of view
+type oldValue struct {
+	val    []byte
+	expire int64 // 0 - never
+	err    error // error of read of value
+}
+
+// View is read-only view of file at the moment it's created
+// Get, Keys and iterators of view see the same state of file,
+// concurrent writes are not visible. View must be released,
+// values changed after view created are kept in memory until release
+// DeleteFile and Restore of file are not seen by view
+// View is safe for concurrent use
+//
+//	view, err := db.Snapshot()
+//	defer view.Release()
+//	keys, err := view.Keys(nil, 10, 0, false)
+//	pairs := view.Gets(keys)
+type View struct {
+	mu       sync.Mutex
+	file     string
+	at       int64    // time of view, unix nano
+	sorted   [][]byte // keys of file
+	old      map[string]oldValue
+	released bool
+}
+
+// NewView return view of file, see View
+// File will be opened (created) if needed
+func NewView(file string) (*View, error) {
+	f, err := openFile(file)
+	if err != nil {
+		return nil, err
+	}
+	// writes of file are finished and new ones wait
+	f.Lock()
+	defer f.Unlock()
+	v := &View{file: file, at: now().UnixNano(), sorted: f.sortedKeys(), old: make(map[string]oldValue)}
+	views.Lock()
+	views.m[file] = append(views.m[file], v)
+	views.Unlock()
+	return v, nil
+}
+
+// preserve save value of key in views of file before key changed,
+// file must be locked (read or write) and key must not be changed concurrently
+func (f *dbFile) preserve(key []byte) {
+	var old *oldValue
+	for _, v := range viewsOf(f.name) {
+		v.mu.Lock()
+		if _, ok := v.old[string(key)]; !ok && !v.released && v.contains(key) {
+			if old == nil {
+				old = &oldValue{}
+				old.val, old.err = f.read(key)
+				old.expire, _ = f.expireOf(key)
+			}
+			v.old[string(key)] = *old
+		}
+		v.mu.Unlock()
+	}
+}
+
+// expireOf return expiration time of key and true if key has it
+func (f *dbFile) expireOf(key []byte) (int64, bool) {
+	f.ttlMu.Lock()
+	defer f.ttlMu.Unlock()
+	expire, ok := f.ttl[string(key)]
+	return expire, ok
+}
+
+// Release view, it can't be used after release
+func (v *View) Release() error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.released {
+		return ErrReleased
+	}
+	v.released = true
+	v.sorted, v.old = nil, nil
+	views.Lock()
+	defer views.Unlock()
+	rest := views.m[v.file][:0:0]
+	for _, other := range views.m[v.file] {
+		if other != v {
+			rest = append(rest, other)
+		}
+	}
+	if len(rest) == 0 {
+		delete(views.m, v.file)
+	} else {
+		views.m[v.file] = rest
+	}
+	return nil
+}
+
+// File return name of file of view
+func (v *View) File() string {
+	return v.file
+}
+
+// contains return true if key was in file at the moment of view, mu must be locked
+func (v *View) contains(key []byte) bool {
+	i := sort.Search(len(v.sorted), func(i int) bool {
+		return bytes.Compare(v.sorted[i], key) >= 0
+	})
+	return i < len(v.sorted) && bytes.Equal(v.sorted[i], key)
+}
+
+// lock file and view, so key can't be preserved while it's read
+// Return unlock function
+func (v *View) lock() (*dbFile, func(), error) {
+	f, err := openFile(v.file)
+	if err != nil {
+		return nil, nil, err
+	}
+	f.RLock()
+	v.mu.Lock()
+	if v.released {
+		v.mu.Unlock()
+		f.RUnlock()
+		return nil, nil, ErrReleased
+	}
+	return f, func() {
+		v.mu.Unlock()
+		f.RUnlock()
+	}, nil
+}
+
+// get return value of key at the moment of view, file and view must be locked
+func (v *View) get(f *dbFile, key []byte) ([]byte, error) {
+	if !v.contains(key) {
+		return nil, pudge.ErrKeyNotFound
+	}
+	if old, ok := v.old[string(key)]; ok {
+		switch {
+		case old.err != nil:
+			return nil, old.err
+		case v.expiredAt(old.expire):
+			return nil, pudge.ErrKeyNotFound
+		}
+		return append([]byte(nil), old.val...), nil
+	}
+	if expire, _ := f.expireOf(key); v.expiredAt(expire) {
+		return nil, pudge.ErrKeyNotFound
+	}
+	return f.read(key)
+}
+
+// expiredAt return true if expiration time is before view
+func (v *View) expiredAt(expire int64) bool {
+	return expire != 0 && expire <= v.at
+}
+
+// Get return value by key at the moment of view
+func (v *View) Get(key []byte) ([]byte, error) {
+	f, unlock, err := v.lock()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	return v.get(f, key)
+}
+
+// Has return true if key existed at the moment of view
+func (v *View) Has(key []byte) (bool, error) {
+	_, err := v.Get(key)
+	if err == pudge.ErrKeyNotFound {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// Gets return key/value pairs at the moment of view, see Gets
+// Corrupted value is skipped, use Get to get error
+func (v *View) Gets(keys [][]byte) (result [][]byte) {
+	f, unlock, err := v.lock()
+	if err != nil {
+		return nil
+	}
+	defer unlock()
+	for _, key := range keys {
+		if val, err := v.get(f, key); err == nil {
+			result = append(result, key, val)
+		}
+	}
+	return result
+}
+
+// Count return count of keys at the moment of view
+func (v *View) Count() (uint64, error) {
+	f, unlock, err := v.lock()
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+	var cnt uint64
+	for _, key := range v.sorted {
+		if !v.expired(f, key) {
+			cnt++
+		}
+	}
+	return cnt, nil
+}
+
+// expired return true if key expired at the moment of view,
+// file and view must be locked
+func (v *View) expired(f *dbFile, key []byte) bool {
+	if old, ok := v.old[string(key)]; ok {
+		return v.expiredAt(old.expire)
+	}
+	expire, _ := f.expireOf(key)
+	return v.expiredAt(expire)
+}
+
+// Keys return keys at the moment of view, params as in Keys
+func (v *View) Keys(from []byte, limit, offset uint32, asc bool) ([][]byte, error) {
+	opts := &IteratorOptions{}
+	switch {
+	case len(from) > 1 && from[len(from)-1] == '*':
+		opts.Prefix = from[:len(from)-1]
+	case from != nil && asc:
+		opts.Start = append(append([]byte(nil), from...), 0)
+	case from != nil:
+		opts.End = from
+	}
+	return collectKeys(v.NewIterator(opts), int(limit), int(offset), asc)
+}
+
+// NewIterator return iterator over keys of view, opts may be nil
+func (v *View) NewIterator(opts *IteratorOptions) *Iterator {
+	it := NewIterator(v.file, opts)
+	it.view = v
+	return it
+}
+
+// after return first key of view greater than key (or equal if inclusive),
+// skip expired keys; return nil if not found
+func (v *View) after(key []byte, inclusive bool) ([]byte, error) {
+	f, unlock, err := v.lock()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	i := 0
+	if key != nil {
+		i = sort.Search(len(v.sorted), func(i int) bool {
+			c := bytes.Compare(v.sorted[i], key)
+			return c > 0 || (inclusive && c == 0)
+		})
+	}
+	for ; i < len(v.sorted); i++ {
+		if !v.expired(f, v.sorted[i]) {
+			return v.sorted[i], nil
+		}
+	}
+	return nil, nil
+}
+
+// before return last key of view less than key (or equal if inclusive),
+// skip expired keys; return nil if not found
+func (v *View) before(key []byte, inclusive bool) ([]byte, error) {
+	f, unlock, err := v.lock()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	i := len(v.sorted)
+	if key != nil {
+		i = sort.Search(len(v.sorted), func(i int) bool {
+			c := bytes.Compare(v.sorted[i], key)
+			return c > 0 || (!inclusive && c == 0)
+		})
+	}
+	for ; i > 0; i-- {
+		if !v.expired(f, v.sorted[i-1]) {
+			return v.sorted[i-1], nil
+		}
+	}
+	return nil, nil
+}
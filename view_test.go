@@ -0,0 +1,129 @@
+package slowpoke
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/recoilme/pudge"
+)
+
+func TestView(t *testing.T) {
+	f := "test/TestView.db"
+	DeleteFile(f)
+	defer DeleteFile(f)
+	defer clock.stop()()
+	db, err := Open(f, nil)
+	ch(err, t)
+	defer db.Close()
+	for i := 0; i < 10; i++ {
+		ch(db.Set([]byte(fmt.Sprintf("%02d", i)), []byte(fmt.Sprintf("v%d", i))), t)
+	}
+	ch(db.SetWithTTL([]byte("ttl"), []byte("t"), time.Minute), t)
+	view, err := db.Snapshot()
+	ch(err, t)
+
+	// changes after view are not visible
+	ch(db.Set([]byte("03"), []byte("new")), t)
+	ch(db.Set([]byte("10"), []byte("v10")), t)
+	_, err = db.Delete([]byte("05"))
+	ch(err, t)
+	ch(db.Set([]byte("ttl"), []byte("t2")), t)
+	clock.add(2 * time.Minute)
+	if v, err := view.Get([]byte("03")); err != nil || string(v) != "v3" {
+		t.Error("get of changed key", string(v), err)
+	}
+	if v, err := view.Get([]byte("05")); err != nil || string(v) != "v5" {
+		t.Error("get of deleted key", string(v), err)
+	}
+	if _, err := view.Get([]byte("10")); err != pudge.ErrKeyNotFound {
+		t.Error("get of new key", err)
+	}
+	if v, err := view.Get([]byte("ttl")); err != nil || string(v) != "t" {
+		t.Error("key expired after view", string(v), err)
+	}
+	if cnt, err := view.Count(); err != nil || cnt != 11 {
+		t.Error("count", cnt, err)
+	}
+	keys, err := view.Keys(nil, 3, 0, false)
+	ch(err, t)
+	if len(keys) != 3 || string(keys[0]) != "ttl" || string(keys[1]) != "09" {
+		t.Error("keys", keys)
+	}
+	if keys, _ = view.Keys([]byte("04"), 2, 0, true); len(keys) != 2 || string(keys[0]) != "05" {
+		t.Error("keys from", keys)
+	}
+	pairs := view.Gets([][]byte{[]byte("03"), []byte("10")})
+	if len(pairs) != 2 || string(pairs[1]) != "v3" {
+		t.Error("gets", pairs)
+	}
+	it := view.NewIterator(&IteratorOptions{Prefix: []byte("0")})
+	n := 0
+	for it.Next() {
+		if want := fmt.Sprintf("v%d", n); string(it.Value()) != want {
+			t.Error("iterator", string(it.Key()), string(it.Value()))
+		}
+		n++
+	}
+	ch(it.Close(), t)
+	if n != 10 {
+		t.Error("iterator count", n)
+	}
+
+	// current state is not changed by view
+	if v, err := db.Get([]byte("03")); err != nil || string(v) != "new" {
+		t.Error("get of file", string(v), err)
+	}
+	ch(view.Release(), t)
+	if _, err = view.Get([]byte("03")); err != ErrReleased {
+		t.Error("get of released view", err)
+	}
+	if len(viewsOf(f)) != 0 {
+		t.Error("view not removed")
+	}
+}
+
+func TestViewConcurrent(t *testing.T) {
+	f := "test/TestViewConcurrent.db"
+	DeleteFile(f)
+	defer DeleteFile(f)
+	// values of keys are always equal, view must not see half of update
+	for i := 0; i < 50; i++ {
+		ch(Set(f, []byte(fmt.Sprintf("%02d", i)), []byte("0")), t)
+	}
+	var wg sync.WaitGroup
+	done := make(chan struct{})
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 1; ; i++ {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			tx, err := Begin(f)
+			ch(err, t)
+			for k := 0; k < 50; k++ {
+				ch(tx.Set([]byte(fmt.Sprintf("%02d", k)), []byte(fmt.Sprint(i))), t)
+			}
+			ch(tx.Commit(), t)
+		}
+	}()
+	for n := 0; n < 20; n++ {
+		view, err := NewView(f)
+		ch(err, t)
+		keys, err := view.Keys(nil, 0, 0, true)
+		ch(err, t)
+		pairs := view.Gets(keys)
+		for i := 3; i < len(pairs); i += 2 {
+			if string(pairs[i]) != string(pairs[1]) {
+				t.Fatal("view is not consistent", string(pairs[1]), string(pairs[i]))
+			}
+		}
+		ch(view.Release(), t)
+	}
+	close(done)
+	wg.Wait()
+}
@@ -0,0 +1,168 @@
+package slowpoke
+
+import (
+	"bytes"
+	"sync"
+	"sync/atomic"
+)
+
+// Writers publish changes of watched file without blocking: event is
+// dropped if channel of watcher is full. First dropped event is replaced
+// by EventLagged (one place in channel is kept for it), events are sent
+// again when consumer read some of them.
+
+// watchBuffer is count of events in channel of watcher
+const watchBuffer = 1024
+
+// EventType is type of change of key
+type EventType byte
+
+const (
+	// EventPut - key stored
+	EventPut EventType = iota + 1
+	// EventDelete - key deleted (or expired)
+	EventDelete
+	// EventLagged - consumer is slow and events from Seq are lost,
+	// read keys again; next events are sent when channel has space
+	EventLagged
+)
+
+func (t EventType) String() string {
+	switch t {
+	case EventPut:
+		return "put"
+	case EventDelete:
+		return "delete"
+	case EventLagged:
+		return "lagged"
+	}
+	return "unknown"
+}
+
+// Event is change of key of watched file
+// Seq is number of change of file, it's increased by every change
+// while file watched, so it continues when file watched again,
+// but it's started again when file reopened
+type Event struct {
+	Type  EventType
+	Key   []byte
+	Value []byte // nil for EventDelete
+	Seq   uint64
+}
+
+// watcher is subscription on changes of keys with prefix
+type watcher struct {
+	prefix []byte
+	ch     chan Event
+	lagged bool // events are dropped until channel has space
+}
+
+// feed is watchers of file
+type feed struct {
+	sync.Mutex
+	watchers []*watcher
+}
+
+// feeds contains feeds of watched files
+var feeds = struct {
+	sync.RWMutex
+	m map[string]*feed
+}{m: make(map[string]*feed)}
+
+// Watch return channel of changes of keys with prefix (nil - all keys)
+// of file and func to cancel watch, channel is closed by cancel
+// Changes are sent after they stored; Restore and DeleteFile are not sent
+// Consumer must read channel quickly, if channel is full events are dropped
+// and EventLagged is sent
+//
+//	events, cancel := slowpoke.Watch(file, []byte("user:"))
+//	defer cancel()
+//	for e := range events {
+//		if e.Type == slowpoke.EventLagged {
+//			// reload keys
+//		}
+//	}
+func Watch(file string, prefix []byte) (<-chan Event, func()) {
+	w := &watcher{prefix: append([]byte(nil), prefix...), ch: make(chan Event, watchBuffer+1)}
+	feeds.Lock()
+	fd := feeds.m[file]
+	if fd == nil {
+		fd = &feed{}
+		feeds.m[file] = fd
+	}
+	fd.Lock()
+	fd.watchers = append(fd.watchers, w)
+	fd.Unlock()
+	feeds.Unlock()
+	var once sync.Once
+	return w.ch, func() {
+		once.Do(func() {
+			unwatch(file, w)
+		})
+	}
+}
+
+// unwatch remove watcher of file and close its channel
+func unwatch(file string, w *watcher) {
+	feeds.Lock()
+	defer feeds.Unlock()
+	fd := feeds.m[file]
+	fd.Lock()
+	defer fd.Unlock()
+	rest := fd.watchers[:0:0]
+	for _, other := range fd.watchers {
+		if other != w {
+			rest = append(rest, other)
+		}
+	}
+	fd.watchers = rest
+	if len(rest) == 0 {
+		delete(feeds.m, file)
+	}
+	close(w.ch)
+}
+
+// publish send change of key to watchers of file, val is nil for delete
+func (f *dbFile) publish(t EventType, key, val []byte) {
+	feeds.RLock()
+	fd := feeds.m[f.name]
+	feeds.RUnlock()
+	if fd == nil {
+		return
+	}
+	fd.Lock()
+	defer fd.Unlock()
+	// seq is kept by file, feed is removed with last watcher
+	seq := atomic.AddUint64(&f.seq, 1)
+	var e *Event
+	for _, w := range fd.watchers {
+		if !bytes.HasPrefix(key, w.prefix) {
+			continue
+		}
+		if e == nil {
+			e = &Event{Type: t, Key: append([]byte(nil), key...), Seq: seq}
+			if t == EventPut {
+				e.Value = append([]byte{}, val...)
+			}
+		}
+		w.send(*e)
+	}
+}
+
+// send event without blocking, feed must be locked
+func (w *watcher) send(e Event) {
+	if w.lagged {
+		if len(w.ch) >= watchBuffer {
+			return
+		}
+		w.lagged = false
+	}
+	if len(w.ch) >= watchBuffer {
+		w.lagged = true
+		e = Event{Type: EventLagged, Seq: e.Seq}
+	}
+	select {
+	case w.ch <- e:
+	default:
+	}
+}
@@ -0,0 +1,85 @@
+package slowpoke
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestWatch(t *testing.T) {
+	f := "test/TestWatch.db"
+	DeleteFile(f)
+	defer DeleteFile(f)
+	events, cancel := Watch(f, []byte("user:"))
+	all, cancelAll := Watch(f, nil)
+	defer cancelAll()
+
+	ch(Set(f, []byte("user:1"), []byte("a")), t)
+	ch(Set(f, []byte("post:1"), []byte("b")), t)
+	_, err := Delete(f, []byte("user:1"))
+	ch(err, t)
+	tx, err := Begin(f)
+	ch(err, t)
+	ch(tx.Set([]byte("user:2"), []byte("c")), t)
+	ch(tx.Commit(), t)
+
+	want := []Event{
+		{Type: EventPut, Key: []byte("user:1"), Value: []byte("a"), Seq: 1},
+		{Type: EventDelete, Key: []byte("user:1"), Seq: 3},
+		{Type: EventPut, Key: []byte("user:2"), Value: []byte("c"), Seq: 4},
+	}
+	for _, w := range want {
+		e := <-events
+		if e.Type != w.Type || string(e.Key) != string(w.Key) || string(e.Value) != string(w.Value) || e.Seq != w.Seq {
+			t.Error("event", e, "want", w)
+		}
+	}
+	if len(all) != 4 {
+		t.Error("events of all keys", len(all))
+	}
+	cancel()
+	cancel()
+	if _, ok := <-events; ok {
+		t.Error("channel not closed")
+	}
+	ch(Set(f, []byte("user:3"), []byte("d")), t)
+	if len(all) != 5 {
+		t.Error("events after cancel of other watcher", len(all))
+	}
+
+	// seq continues when file watched again
+	cancelAll()
+	events, cancel = Watch(f, nil)
+	defer cancel()
+	ch(Set(f, []byte("user:4"), []byte("e")), t)
+	if e := <-events; e.Seq != 6 {
+		t.Error("seq after watch again", e)
+	}
+}
+
+func TestWatchLagged(t *testing.T) {
+	f := "test/TestWatchLagged.db"
+	DeleteFile(f)
+	defer DeleteFile(f)
+	events, cancel := Watch(f, nil)
+	defer cancel()
+	for i := 0; i < watchBuffer+10; i++ {
+		ch(Set(f, []byte(fmt.Sprintf("%04d", i)), []byte("v")), t)
+	}
+	for i := 0; i < watchBuffer; i++ {
+		if e := <-events; e.Type != EventPut || e.Seq != uint64(i+1) {
+			t.Fatal("event", i, e)
+		}
+	}
+	if e := <-events; e.Type != EventLagged || e.Seq != watchBuffer+1 {
+		t.Error("lagged", e)
+	}
+	if len(events) != 0 {
+		t.Error("events after lagged", len(events))
+	}
+
+	// events are sent again
+	ch(Set(f, []byte("next"), []byte("v")), t)
+	if e := <-events; e.Type != EventPut || string(e.Key) != "next" || e.Seq != watchBuffer+11 {
+		t.Error("event after lagged", e)
+	}
+}